@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+
+	"github.com/coder/websocket"
 )
 
 type Config struct {
@@ -10,6 +12,9 @@ type Config struct {
 	BaseURL     string
 	Domain      string
 	DevMode     bool
+
+	// WebSocket permessage-deflate: "context" (default), "no-context" or "off"
+	WSCompression string
 }
 
 func Load() *Config {
@@ -19,10 +24,25 @@ func Load() *Config {
 		BaseURL:    getEnv("BASE_URL", "http://localhost:8080"),
 		Domain:     getEnv("DOMAIN", "cloud.devport.app"),
 		DevMode:    getEnv("DEV_MODE", "") == "true",
+
+		WSCompression: getEnv("WS_COMPRESSION", "context"),
 	}
 	return cfg
 }
 
+// CompressionMode returns the permessage-deflate mode offered on WebSocket
+// connections
+func (c *Config) CompressionMode() websocket.CompressionMode {
+	switch c.WSCompression {
+	case "off":
+		return websocket.CompressionDisabled
+	case "no-context":
+		return websocket.CompressionNoContextTakeover
+	default:
+		return websocket.CompressionContextTakeover
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -52,7 +52,8 @@ func (h *ClientHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Accept WebSocket connection
 	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"},
+		OriginPatterns:  []string{"*"},
+		CompressionMode: h.cfg.CompressionMode(),
 	})
 	if err != nil {
 		log.Printf("WebSocket accept error: %v", err)
@@ -88,8 +89,10 @@ func (h *ClientHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
+		// Envelopes carry JSON only, so MessagePack is not relayed
 		if msgType != websocket.MessageText {
-			continue
+			conn.Close(websocket.StatusUnsupportedData, "binary frames are not relayed, use JSON encoding")
+			break
 		}
 
 		// Forward message to relay
@@ -0,0 +1,143 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noon-R/Devport/relay/config"
+	"github.com/Noon-R/Devport/relay/store"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+const testHost = "abc.relay.test"
+
+// relayPair serves the relay and client endpoints and connects a local PC
+// relay for the subdomain abc
+func relayPair(t *testing.T) (url string, relayConn *websocket.Conn) {
+	t.Helper()
+	cfg := &config.Config{Domain: "relay.test"}
+	st := store.NewStore()
+	st.RegisterRelay("abc", "relay-token")
+	relayHandler := NewRelayHandler(cfg, st)
+
+	mux := http.NewServeMux()
+	mux.Handle("/relay", relayHandler)
+	mux.Handle("/ws", NewClientHandler(cfg, st, relayHandler))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	url = "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ctx := context.Background()
+	relayConn, _, err := websocket.Dial(ctx, url+"/relay", &websocket.DialOptions{Host: testHost})
+	if err != nil {
+		t.Fatalf("Relay dial failed: %v", err)
+	}
+	t.Cleanup(func() { relayConn.CloseNow() })
+	register := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "register",
+		"params":  map[string]string{"relay_token": "relay-token"},
+		"id":      1,
+	}
+	if err := wsjson.Write(ctx, relayConn, register); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	var resp RelayAuthResponse
+	if err := wsjson.Read(ctx, relayConn, &resp); err != nil || resp.Error != nil {
+		t.Fatalf("Register failed: %v %+v", err, resp.Error)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for relayHandler.GetMultiplexer("abc") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Relay was not connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return url, relayConn
+}
+
+func readEnvelope(t *testing.T, ctx context.Context, conn *websocket.Conn) Envelope {
+	t.Helper()
+	msgType, data, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if msgType != websocket.MessageText {
+		t.Fatalf("Expected a text envelope, got %v", msgType)
+	}
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		t.Fatalf("Invalid envelope %s: %v", data, err)
+	}
+	return envelope
+}
+
+func TestClientMessagesAreRelayed(t *testing.T) {
+	url, relayConn := relayPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, _, err := websocket.Dial(ctx, url+"/ws", &websocket.DialOptions{Host: testHost})
+	if err != nil {
+		t.Fatalf("Client dial failed: %v", err)
+	}
+	defer client.CloseNow()
+
+	connected := readEnvelope(t, ctx, relayConn)
+	if connected.Type != EnvelopeTypeConnected {
+		t.Fatalf("Expected a connected envelope, got %+v", connected)
+	}
+
+	request := `{"jsonrpc":"2.0","method":"ping","id":1}`
+	if err := client.Write(ctx, websocket.MessageText, []byte(request)); err != nil {
+		t.Fatalf("Client write failed: %v", err)
+	}
+	message := readEnvelope(t, ctx, relayConn)
+	if message.Type != EnvelopeTypeMessage || message.ConnectionID != connected.ConnectionID || string(message.Payload) != request {
+		t.Fatalf("Unexpected envelope: %+v", message)
+	}
+
+	response := `{"jsonrpc":"2.0","result":{"pong":true},"id":1}`
+	reply := Envelope{ConnectionID: connected.ConnectionID, Type: EnvelopeTypeMessage, Payload: []byte(response)}
+	if err := wsjson.Write(ctx, relayConn, reply); err != nil {
+		t.Fatalf("Relay write failed: %v", err)
+	}
+	msgType, data, err := client.Read(ctx)
+	if err != nil || msgType != websocket.MessageText || string(data) != response {
+		t.Fatalf("Expected the response as text, got %v %s %v", msgType, data, err)
+	}
+}
+
+func TestClientBinaryFramesAreRefused(t *testing.T) {
+	url, relayConn := relayPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, _, err := websocket.Dial(ctx, url+"/ws", &websocket.DialOptions{Host: testHost})
+	if err != nil {
+		t.Fatalf("Client dial failed: %v", err)
+	}
+	defer client.CloseNow()
+	connected := readEnvelope(t, ctx, relayConn)
+
+	// A MessagePack request closes the client instead of being relayed
+	if err := client.Write(ctx, websocket.MessageBinary, []byte{0x81, 0xa2, 'i', 'd', 0x01}); err != nil {
+		t.Fatalf("Client write failed: %v", err)
+	}
+	_, _, err = client.Read(ctx)
+	if status := websocket.CloseStatus(err); status != websocket.StatusUnsupportedData {
+		t.Errorf("Expected close status %v, got %v (%v)", websocket.StatusUnsupportedData, status, err)
+	}
+
+	disconnected := readEnvelope(t, ctx, relayConn)
+	if disconnected.Type != EnvelopeTypeDisconnected || disconnected.ConnectionID != connected.ConnectionID {
+		t.Errorf("Expected only a disconnected envelope, got %+v", disconnected)
+	}
+}
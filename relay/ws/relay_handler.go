@@ -70,7 +70,8 @@ func (h *RelayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Accept WebSocket connection
 	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"},
+		OriginPatterns:  []string{"*"},
+		CompressionMode: h.cfg.CompressionMode(),
 	})
 	if err != nil {
 		log.Printf("WebSocket accept error: %v", err)
@@ -58,3 +58,9 @@ type Agent interface {
 	// Close terminates the agent process
 	Close() error
 }
+
+// Titler is implemented by agents that can summarize a conversation into a
+// short title with a side request that does not affect the conversation
+type Titler interface {
+	GenerateTitle(ctx context.Context, transcript string) (string, error)
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"os/exec"
+	"strings"
 	"sync"
 	"time"
 
@@ -353,6 +354,22 @@ func (c *Claude) RespondToQuestion(ctx context.Context, questionID string, answe
 	return nil
 }
 
+// GenerateTitle asks a separate one-shot Claude CLI invocation for a short
+// title, leaving the session's conversation untouched
+func (c *Claude) GenerateTitle(ctx context.Context, transcript string) (string, error) {
+	prompt := "Write a concise title (at most 6 words) for the following conversation. " +
+		"Reply with the title only, without quotes or punctuation at the end.\n\n" + transcript
+
+	cmd := exec.CommandContext(ctx, "claude", "-p", "--output-format", "text", prompt)
+	cmd.Dir = c.workDir
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("generate title: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
 // IsRunning returns true if the agent is currently processing
 func (c *Claude) IsRunning() bool {
 	c.mu.Lock()
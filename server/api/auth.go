@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Noon-R/Devport/server/auth"
+)
+
+// authorize checks that the request was authenticated by the guard
+// middleware (see auth.Guard) with a token carrying scope. It writes 401 or
+// 403 and returns false otherwise.
+func authorize(w http.ResponseWriter, r *http.Request, scope string) bool {
+	token := auth.TokenFrom(r.Context())
+	if token == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if !token.Has(scope) {
+		http.Error(w, "Missing scope: "+scope, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// TicketRoute reports whether a request may authenticate with a ticket in
+// its URL: the WebSocket upgrade, SSE streams and downloads (file reads,
+// exports and offloaded outputs), which clients open without headers
+func TicketRoute(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	if r.URL.Path == "/ws" || strings.HasPrefix(r.URL.Path, "/api/fs/") {
+		return true
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 4 || parts[0] != "api" || parts[1] != "sessions" {
+		return false
+	}
+	switch {
+	case len(parts) == 4:
+		return parts[3] == "events" || parts[3] == "export"
+	case len(parts) == 5:
+		return parts[3] == "outputs"
+	}
+	return false
+}
+
+// AuthHandler issues access tokens, refresh tokens and tickets to REST
+// clients
+type AuthHandler struct {
+	tokens *auth.Store
+}
+
+// NewAuthHandler creates a new auth handler
+func NewAuthHandler(tokens *auth.Store) *AuthHandler {
+	return &AuthHandler{tokens: tokens}
+}
+
+// ServeHTTP implements http.Handler
+//
+// POST /api/auth/token   {"token": "<secret>"}       starts a session
+// POST /api/auth/refresh {"refresh_token": "<token>"} renews it
+// POST /api/auth/ticket  (Authorization header)       issues a ticket
+func (h *AuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/auth") {
+	case "/token":
+		h.handleToken(w, r)
+	case "/refresh":
+		h.handleRefresh(w, r)
+	case "/ticket":
+		h.handleTicket(w, r)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleToken exchanges a secret for a session
+func (h *AuthHandler) handleToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.tokens.Authenticate(req.Token)
+	if err != nil {
+		auth.ClientFrom(r.Context()).Fail("POST /api/auth/token", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	auth.ClientFrom(r.Context()).Succeed()
+	session, err := h.tokens.Issue(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeSession(w, token, session)
+}
+
+// handleRefresh exchanges a refresh token for a new session
+func (h *AuthHandler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, session, err := h.tokens.Refresh(req.RefreshToken)
+	if err != nil {
+		auth.ClientFrom(r.Context()).Fail("POST /api/auth/refresh", err)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	auth.ClientFrom(r.Context()).Succeed()
+	writeSession(w, token, session)
+}
+
+// handleTicket issues a single-use ticket for the caller's token
+func (h *AuthHandler) handleTicket(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFrom(r.Context())
+	if token == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ticket, err := h.tokens.Ticket(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ticket":     ticket,
+		"expires_in": int(auth.TicketTTL / time.Second),
+	})
+}
+
+func writeSession(w http.ResponseWriter, token *auth.Token, session *auth.Session) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  session.AccessToken,
+		"refresh_token": session.RefreshToken,
+		"expires_in":    session.ExpiresIn,
+		"scopes":        token.Scopes,
+	})
+}
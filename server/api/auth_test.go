@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTicketRoute(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{http.MethodGet, "/ws", true},
+		{http.MethodGet, "/api/fs/src/main.go", true},
+		{http.MethodGet, "/api/sessions/s1/events", true},
+		{http.MethodGet, "/api/sessions/s1/export", true},
+		{http.MethodGet, "/api/sessions/s1/outputs/o1", true},
+		{http.MethodPut, "/api/fs/src/main.go", false},
+		{http.MethodDelete, "/api/fs/src/main.go", false},
+		{http.MethodPost, "/api/sessions/s1/messages", false},
+		{http.MethodGet, "/api/sessions/s1/messages", false},
+		{http.MethodGet, "/api/sessions/s1/outputs", false},
+		{http.MethodGet, "/api/sessions/s1/export/extra", false},
+		{http.MethodGet, "/api/fs", false},
+		{http.MethodGet, "/api/git/status", false},
+		{http.MethodGet, "/api/auth/tokens", false},
+		{http.MethodGet, "/ws/other", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := TicketRoute(r); got != tt.want {
+			t.Errorf("TicketRoute(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
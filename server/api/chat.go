@@ -1,27 +1,37 @@
 package api
 
 import (
-	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
 
-	"github.com/Noon-R/Devport/server/agent"
+	"github.com/Noon-R/Devport/server/auth"
 	"github.com/Noon-R/Devport/server/process"
 	"github.com/Noon-R/Devport/server/session"
-	"github.com/google/uuid"
+	"github.com/Noon-R/Devport/server/stream"
+	"github.com/Noon-R/Devport/server/turn"
 )
 
+// maxHistoryPage is the largest page of history returned at once
+const maxHistoryPage = 1000
+
+// maxImportSize limits the size of uploaded session archives
+const maxImportSize = 50 << 20
+
 // ChatHandler handles chat REST API operations
 type ChatHandler struct {
-	authToken      string
 	sessionStore   *session.Store
 	processManager *process.Manager
 
 	// Pending responses for async operations
 	pendingResponses sync.Map // map[requestID]chan *ResponseEvent
+
+	streams *stream.Hub
+	turns   *turn.Runner
 }
 
 // ResponseEvent represents an event to be sent back to the client
@@ -32,23 +42,34 @@ type ResponseEvent struct {
 }
 
 // NewChatHandler creates a new chat handler
-func NewChatHandler(authToken string, sessionStore *session.Store, processManager *process.Manager) *ChatHandler {
+func NewChatHandler(sessionStore *session.Store, processManager *process.Manager) *ChatHandler {
 	return &ChatHandler{
-		authToken:      authToken,
 		sessionStore:   sessionStore,
 		processManager: processManager,
+		turns:          turn.NewRunner(sessionStore, processManager, nil, nil),
 	}
 }
 
+// SetTurnRunner sets the runner of chat turns, shared with the WebSocket
+// API so that turns are streamed to every attached client
+func (h *ChatHandler) SetTurnRunner(turns *turn.Runner) {
+	h.turns = turns
+}
+
+// SetStreams sets the hub from which session events are streamed to SSE
+// clients
+func (h *ChatHandler) SetStreams(streams *stream.Hub) {
+	h.streams = streams
+}
+
 // ServeHTTP implements http.Handler
 func (h *ChatHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Check authentication
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		token = r.URL.Query().Get("token")
+	// Reading needs the read scope, anything else chat
+	scope := auth.ScopeChat
+	if r.Method == http.MethodGet {
+		scope = auth.ScopeRead
 	}
-	if strings.TrimPrefix(token, "Bearer ") != h.authToken {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if !authorize(w, r, scope) {
 		return
 	}
 
@@ -57,14 +78,30 @@ func (h *ChatHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(strings.Trim(path, "/"), "/")
 
 	switch {
+	// POST /api/sessions/import - Import a session archive or CLI transcript
+	case len(parts) == 2 && parts[0] == "sessions" && parts[1] == "import" && r.Method == http.MethodPost:
+		h.handleImport(w, r)
+
 	// GET /api/sessions/:id/messages - Get message history
 	case len(parts) == 3 && parts[0] == "sessions" && parts[2] == "messages" && r.Method == http.MethodGet:
 		h.handleGetHistory(w, r, parts[1])
 
+	// GET /api/sessions/:id/events - Stream chat notifications (SSE)
+	case len(parts) == 3 && parts[0] == "sessions" && parts[2] == "events" && r.Method == http.MethodGet:
+		h.handleEvents(w, r, parts[1])
+
 	// POST /api/sessions/:id/messages - Send message
 	case len(parts) == 3 && parts[0] == "sessions" && parts[2] == "messages" && r.Method == http.MethodPost:
 		h.handleSendMessage(w, r, parts[1])
 
+	// GET /api/sessions/:id/export - Export session transcript
+	case len(parts) == 3 && parts[0] == "sessions" && parts[2] == "export" && r.Method == http.MethodGet:
+		h.handleExport(w, r, parts[1])
+
+	// GET /api/sessions/:id/outputs/:ref - Read an offloaded tool output
+	case len(parts) == 4 && parts[0] == "sessions" && parts[2] == "outputs" && r.Method == http.MethodGet:
+		h.handleGetOutput(w, r, parts[1], parts[3])
+
 	// POST /api/sessions/:id/cancel - Cancel generation
 	case len(parts) == 3 && parts[0] == "sessions" && parts[2] == "cancel" && r.Method == http.MethodPost:
 		h.handleCancel(w, r, parts[1])
@@ -82,7 +119,8 @@ func (h *ChatHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleGetHistory returns message history for a session
+// handleGetHistory returns message history for a session. The after and
+// before cursors accept a sequence number or, for older clients, a message ID.
 func (h *ChatHandler) handleGetHistory(w http.ResponseWriter, r *http.Request, sessionID string) {
 	// Check if session exists
 	sess := h.sessionStore.Get(sessionID)
@@ -91,32 +129,133 @@ func (h *ChatHandler) handleGetHistory(w http.ResponseWriter, r *http.Request, s
 		return
 	}
 
-	history := h.sessionStore.GetHistory(sessionID)
-
-	// Support pagination via query params
-	afterSeq := r.URL.Query().Get("after")
-	if afterSeq != "" {
-		// Find messages after the given sequence (using message ID as sequence)
-		var filtered []session.HistoryMessage
-		found := false
-		for _, msg := range history {
-			if found {
-				filtered = append(filtered, msg)
-			}
-			if msg.ID == afterSeq {
-				found = true
-			}
+	query := r.URL.Query()
+	var opts session.PageOptions
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
 		}
-		history = filtered
+		opts.Limit = min(limit, maxHistoryPage)
+	}
+
+	var err error
+	if opts.After, err = h.parseCursor(sessionID, query.Get("after")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Before, err = h.parseCursor(sessionID, query.Get("before")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
+	page := h.sessionStore.GetHistoryPage(sessionID, opts)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"session_id": sessionID,
-		"messages":   history,
+		"messages":   page.Messages,
+		"has_more":   page.HasMore,
+		"last_seq":   page.LastSeq,
+	})
+}
+
+// parseCursor converts a history cursor to a sequence number
+func (h *ChatHandler) parseCursor(sessionID, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if seq, err := strconv.ParseInt(value, 10, 64); err == nil && seq >= 0 {
+		return seq, nil
+	}
+	seq, ok := h.sessionStore.FindSeq(sessionID, value)
+	if !ok {
+		return 0, fmt.Errorf("unknown message id: %s", value)
+	}
+	return seq, nil
+}
+
+// handleExport renders a session as a Markdown, HTML or JSON archive
+func (h *ChatHandler) handleExport(w http.ResponseWriter, r *http.Request, sessionID string) {
+	archive, err := h.sessionStore.Export(sessionID)
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "md"
+	}
+
+	var contentType string
+	var write func(io.Writer) error
+	switch format {
+	case "md":
+		contentType = "text/markdown; charset=utf-8"
+		write = archive.WriteMarkdown
+	case "html":
+		contentType = "text/html; charset=utf-8"
+		write = archive.WriteHTML
+	case "json":
+		contentType = "application/json"
+		write = archive.WriteJSON
+	default:
+		http.Error(w, "Unsupported format", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="session-%s.%s"`, sessionID, format))
+	write(w)
+}
+
+// handleImport creates a session from a Devport archive or Claude CLI transcript
+func (h *ChatHandler) handleImport(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	archive, err := session.ParseArchive(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sess, err := h.sessionStore.Import(archive)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session":       sess,
+		"message_count": len(archive.Messages),
 	})
 }
 
+// handleGetOutput returns a tool output offloaded by history compaction
+func (h *ChatHandler) handleGetOutput(w http.ResponseWriter, r *http.Request, sessionID, ref string) {
+	if h.sessionStore.Get(sessionID) == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := h.sessionStore.ReadOutput(sessionID, ref)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
 // handleSendMessage handles sending a message to the session
 func (h *ChatHandler) handleSendMessage(w http.ResponseWriter, r *http.Request, sessionID string) {
 	var req struct {
@@ -134,96 +273,19 @@ func (h *ChatHandler) handleSendMessage(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	// Get or create agent for this session
-	ctx := r.Context()
-	ag, err := h.processManager.GetOrCreate(ctx, sessionID)
+	userMsg, err := h.turns.Start(r.Context(), sessionID, req.Content)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Save user message to history
-	userMsg := session.HistoryMessage{
-		ID:        uuid.New().String(),
-		Role:      "user",
-		Content:   req.Content,
-		Timestamp: time.Now(),
-	}
-	h.sessionStore.AddMessage(sessionID, userMsg)
-
-	// Generate request ID for tracking
-	requestID := uuid.New().String()
-
-	// Return immediately with request ID
+	// Return immediately; the turn is streamed to attached clients
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"request_id":  requestID,
-		"message_id":  userMsg.ID,
-		"session_id":  sessionID,
-		"status":      "accepted",
+		"message_id": userMsg.ID,
+		"session_id": sessionID,
+		"status":     "accepted",
 	})
-
-	// Process message asynchronously
-	go h.processMessage(ctx, sessionID, req.Content, requestID, ag)
-}
-
-// processMessage processes the message and saves the assistant response
-func (h *ChatHandler) processMessage(ctx context.Context, sessionID, content, requestID string, ag agent.Agent) {
-	events, err := ag.SendMessage(ctx, content)
-	if err != nil {
-		return
-	}
-
-	var assistantContent strings.Builder
-	var toolCalls []session.ToolCallInfo
-	assistantMsgID := uuid.New().String()
-
-	for event := range events {
-		switch event.Type {
-		case agent.EventTypeText:
-			assistantContent.WriteString(event.Content)
-
-		case agent.EventTypeToolCall:
-			toolCalls = append(toolCalls, session.ToolCallInfo{
-				ID:     event.ToolUseID,
-				Name:   event.ToolName,
-				Input:  event.ToolInput,
-				Status: "pending",
-			})
-
-		case agent.EventTypeToolResult:
-			for i := range toolCalls {
-				if toolCalls[i].ID == event.ToolUseID {
-					toolCalls[i].Output = event.ToolOutput
-					toolCalls[i].Status = "completed"
-					break
-				}
-			}
-
-		case agent.EventTypeDone, agent.EventTypeInterrupted:
-			// Save assistant message
-			if assistantContent.Len() > 0 || len(toolCalls) > 0 {
-				assistantMsg := session.HistoryMessage{
-					ID:        assistantMsgID,
-					Role:      "assistant",
-					Content:   assistantContent.String(),
-					ToolCalls: toolCalls,
-					Timestamp: time.Now(),
-				}
-				h.sessionStore.AddMessage(sessionID, assistantMsg)
-			}
-
-		case agent.EventTypeSystem:
-			// Save system message
-			sysMsg := session.HistoryMessage{
-				ID:        uuid.New().String(),
-				Role:      "system",
-				Content:   event.Content,
-				Timestamp: time.Now(),
-			}
-			h.sessionStore.AddMessage(sessionID, sysMsg)
-		}
-	}
 }
 
 // handleCancel handles canceling the current generation
@@ -241,6 +303,7 @@ func (h *ChatHandler) handleCancel(w http.ResponseWriter, r *http.Request, sessi
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer h.processManager.Release(sessionID)
 
 	if err := ag.Interrupt(ctx); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -268,6 +331,7 @@ func (h *ChatHandler) handlePermissionResponse(w http.ResponseWriter, r *http.Re
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer h.processManager.Release(req.SessionID)
 
 	if err := ag.RespondToPermission(ctx, permissionID, req.Allowed); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -295,6 +359,7 @@ func (h *ChatHandler) handleQuestionResponse(w http.ResponseWriter, r *http.Requ
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer h.processManager.Release(req.SessionID)
 
 	if err := ag.RespondToQuestion(ctx, questionID, req.Answer); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Noon-R/Devport/server/auth"
+	"github.com/Noon-R/Devport/server/events"
+)
+
+// Long-polling limits for GET /api/events
+const (
+	defaultPollTimeout = 25 * time.Second
+	maxPollTimeout     = 60 * time.Second
+)
+
+// EventsHandler serves server events to clients that cannot keep a
+// WebSocket open, by long polling
+type EventsHandler struct {
+	bus *events.Bus
+}
+
+// NewEventsHandler creates a new events handler
+func NewEventsHandler(bus *events.Bus) *EventsHandler {
+	return &EventsHandler{
+		bus: bus,
+	}
+}
+
+// ServeHTTP implements http.Handler
+//
+// GET /api/events?after=<id>&timeout=<seconds>&session_id=<id>&types=<a,b>
+// returns the events after the given ID, waiting up to timeout for one
+func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, auth.ScopeRead) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	after, err := strconv.ParseInt(query.Get("after"), 10, 64)
+	if err != nil || after < 0 {
+		after = 0
+	}
+	timeout := defaultPollTimeout
+	if v, err := strconv.Atoi(query.Get("timeout")); err == nil && v >= 0 {
+		timeout = min(time.Duration(v)*time.Second, maxPollTimeout)
+	}
+	sessionID := query.Get("session_id")
+	types := map[events.Type]bool{}
+	for _, t := range strings.Split(query.Get("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types[events.Type(t)] = true
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	// Wait until a matching event arrives or the timeout expires
+	matched := []events.Event{}
+	complete := true
+	for len(matched) == 0 && ctx.Err() == nil {
+		batch, ok := h.bus.Since(ctx, after)
+		complete = complete && ok
+		if !ok && len(batch) == 0 {
+			// Unknown cursor, e.g. from before a restart: start over
+			after = h.bus.LastID()
+			break
+		}
+		for _, event := range batch {
+			after = event.ID
+			if sessionID != "" && event.SessionID != sessionID {
+				continue
+			}
+			if len(types) > 0 && !types[event.Type] {
+				continue
+			}
+			matched = append(matched, event)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events":   matched,
+		"last_id":  after,
+		"complete": complete,
+	})
+}
@@ -2,28 +2,59 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"github.com/Noon-R/Devport/server/auth"
+	"github.com/Noon-R/Devport/server/events"
+	"github.com/Noon-R/Devport/server/session"
+	"github.com/Noon-R/Devport/server/workspace"
+)
+
+// stateDirName is the directory holding server state (tokens, audit log,
+// encryption salt, sessions), which the file and git APIs never expose
+const stateDirName = ".devport"
+
+// Errors selecting the work dir of a request
+var (
+	errSessionNotFound = errors.New("session not found")
+	errStatePath       = errors.New("path is inside a server state directory")
 )
 
 // FSHandler handles file system operations
 type FSHandler struct {
-	workDir   string
-	authToken string
+	workDir      string
+	sessionStore *session.Store
+	roots        *workspace.Roots
+	bus          *events.Bus
 }
 
-// NewFSHandler creates a new file system handler
-func NewFSHandler(workDir, authToken string) *FSHandler {
+// NewFSHandler creates a new file system handler. Requests carrying a
+// session_id query parameter are scoped to that session's work dir.
+func NewFSHandler(workDir string, sessionStore *session.Store) *FSHandler {
 	return &FSHandler{
-		workDir:   workDir,
-		authToken: authToken,
+		workDir:      workDir,
+		sessionStore: sessionStore,
 	}
 }
 
+// SetEventBus sets the bus on which file changes made through the API are
+// published
+func (h *FSHandler) SetEventBus(bus *events.Bus) {
+	h.bus = bus
+}
+
+// SetRoots sets the project roots that session work dirs must lie in.
+// Without roots only the default work dir is served.
+func (h *FSHandler) SetRoots(roots *workspace.Roots) {
+	h.roots = roots
+}
+
 // FileInfo represents file metadata
 type FileInfo struct {
 	Name    string `json:"name"`
@@ -35,13 +66,18 @@ type FileInfo struct {
 
 // ServeHTTP implements http.Handler
 func (h *FSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Check authentication
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		token = r.URL.Query().Get("token")
+	// Reading needs the read scope, anything else fs-write
+	scope := auth.ScopeFSWrite
+	if r.Method == http.MethodGet {
+		scope = auth.ScopeRead
+	}
+	if !authorize(w, r, scope) {
+		return
 	}
-	if strings.TrimPrefix(token, "Bearer ") != h.authToken {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+	workDir, err := sessionWorkDir(r, h.workDir, h.sessionStore, h.roots)
+	if err != nil {
+		workDirError(w, err)
 		return
 	}
 
@@ -52,7 +88,11 @@ func (h *FSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Resolve and validate path
-	fullPath, err := h.resolvePath(reqPath)
+	fullPath, err := resolvePath(workDir, reqPath)
+	if errors.Is(err, errStatePath) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
@@ -62,24 +102,40 @@ func (h *FSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		h.handleGet(w, r, fullPath, reqPath)
 	case http.MethodPut:
-		h.handlePut(w, r, fullPath)
+		if h.handlePut(w, r, fullPath) {
+			h.fileChanged(r, fullPath, "write")
+		}
 	case http.MethodDelete:
-		h.handleDelete(w, r, fullPath)
+		if h.handleDelete(w, r, fullPath) {
+			h.fileChanged(r, fullPath, "delete")
+		}
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// fileChanged publishes a file change
+func (h *FSHandler) fileChanged(r *http.Request, fullPath, op string) {
+	h.bus.Publish(events.Event{
+		Type:      events.FileChanged,
+		SessionID: r.URL.Query().Get("session_id"),
+		Data: map[string]interface{}{
+			"path": fullPath,
+			"op":   op,
+		},
+	})
+}
+
 // resolvePath validates and resolves a path to prevent path traversal
-func (h *FSHandler) resolvePath(reqPath string) (string, error) {
+func resolvePath(workDir, reqPath string) (string, error) {
 	// Clean the path
 	cleanPath := filepath.Clean(reqPath)
 
 	// Join with work directory
-	fullPath := filepath.Join(h.workDir, cleanPath)
+	fullPath := filepath.Join(workDir, cleanPath)
 
 	// Ensure the path is within work directory
-	absWorkDir, err := filepath.Abs(h.workDir)
+	absWorkDir, err := filepath.Abs(workDir)
 	if err != nil {
 		return "", err
 	}
@@ -88,13 +144,53 @@ func (h *FSHandler) resolvePath(reqPath string) (string, error) {
 		return "", err
 	}
 
-	if !strings.HasPrefix(absFullPath, absWorkDir) {
+	if !workspace.Contains(absWorkDir, absFullPath) {
 		return "", os.ErrPermission
 	}
+	if inStateDir(absFullPath) {
+		return "", errStatePath
+	}
 
 	return fullPath, nil
 }
 
+// inStateDir reports whether a path, or the file it resolves to through
+// symlinks, lies in a server state directory
+func inStateDir(path string) bool {
+	if hasStateElement(path) {
+		return true
+	}
+	resolved, err := evalExisting(path)
+	return err != nil || hasStateElement(resolved)
+}
+
+func hasStateElement(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == stateDirName {
+			return true
+		}
+	}
+	return false
+}
+
+// evalExisting resolves the symlinks of the longest existing prefix of path,
+// so that files about to be created are checked too
+func evalExisting(path string) (string, error) {
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		parent := filepath.Dir(path)
+		if !os.IsNotExist(err) || parent == path {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
+
 // handleGet handles GET requests - read file or list directory
 func (h *FSHandler) handleGet(w http.ResponseWriter, r *http.Request, fullPath, reqPath string) {
 	info, err := os.Stat(fullPath)
@@ -180,34 +276,35 @@ func (h *FSHandler) readFile(w http.ResponseWriter, fullPath string) {
 }
 
 // handlePut handles PUT requests - write file
-func (h *FSHandler) handlePut(w http.ResponseWriter, r *http.Request, fullPath string) {
+func (h *FSHandler) handlePut(w http.ResponseWriter, r *http.Request, fullPath string) bool {
 	// Ensure parent directory exists
 	dir := filepath.Dir(fullPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	// Read body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	// Write file
 	if err := os.WriteFile(fullPath, body, 0644); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	return true
 }
 
 // handleDelete handles DELETE requests - delete file or directory
-func (h *FSHandler) handleDelete(w http.ResponseWriter, r *http.Request, fullPath string) {
+func (h *FSHandler) handleDelete(w http.ResponseWriter, r *http.Request, fullPath string) bool {
 	info, err := os.Stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -215,7 +312,7 @@ func (h *FSHandler) handleDelete(w http.ResponseWriter, r *http.Request, fullPat
 		} else {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-		return
+		return false
 	}
 
 	if info.IsDir() {
@@ -226,11 +323,49 @@ func (h *FSHandler) handleDelete(w http.ResponseWriter, r *http.Request, fullPat
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	return true
+}
+
+// sessionWorkDir returns the work dir selected by the session_id query
+// parameter, or defaultDir when none is given. Session work dirs are read
+// back from disk, so they are checked against roots again.
+func sessionWorkDir(r *http.Request, defaultDir string, sessionStore *session.Store, roots *workspace.Roots) (string, error) {
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" || sessionStore == nil {
+		return defaultDir, nil
+	}
+	sess := sessionStore.Get(sessionID)
+	if sess == nil {
+		return "", errSessionNotFound
+	}
+	if sess.WorkDir == "" {
+		return defaultDir, nil
+	}
+	if roots == nil {
+		return "", workspace.ErrOutsideRoots
+	}
+	dir, err := roots.Resolve(sess.WorkDir)
+	if err != nil {
+		return "", err
+	}
+	if inStateDir(dir) {
+		return "", errStatePath
+	}
+	return dir, nil
+}
+
+// workDirError writes the response for a failed sessionWorkDir
+func workDirError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Forbidden work dir", http.StatusForbidden)
 }
 
 // getContentType returns MIME type based on file extension
@@ -6,22 +6,38 @@ import (
 	"net/http"
 	"os/exec"
 	"strings"
+
+	"github.com/Noon-R/Devport/server/auth"
+	"github.com/Noon-R/Devport/server/session"
+	"github.com/Noon-R/Devport/server/workspace"
 )
 
 // GitHandler handles Git operations
 type GitHandler struct {
-	workDir   string
-	authToken string
+	workDir      string
+	sessionStore *session.Store
+	roots        *workspace.Roots
 }
 
-// NewGitHandler creates a new Git handler
-func NewGitHandler(workDir, authToken string) *GitHandler {
+// NewGitHandler creates a new Git handler. Requests carrying a session_id
+// query parameter are scoped to that session's work dir.
+func NewGitHandler(workDir string, sessionStore *session.Store) *GitHandler {
 	return &GitHandler{
-		workDir:   workDir,
-		authToken: authToken,
+		workDir:      workDir,
+		sessionStore: sessionStore,
 	}
 }
 
+// SetRoots sets the project roots that session work dirs must lie in.
+// Without roots only the default work dir is served.
+func (h *GitHandler) SetRoots(roots *workspace.Roots) {
+	h.roots = roots
+}
+
+// excludeState is the pathspec keeping server state directories out of
+// status and diff output
+var excludeState = []string{"--", ":(top,exclude,glob)**/" + stateDirName + "/**"}
+
 // DiffFile represents a file in the diff
 type DiffFile struct {
 	Path      string `json:"path"`
@@ -52,13 +68,18 @@ type StatusResponse struct {
 
 // ServeHTTP implements http.Handler
 func (h *GitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Check authentication
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		token = r.URL.Query().Get("token")
+	// Reading needs the read scope, anything else git-write
+	scope := auth.ScopeGitWrite
+	if r.Method == http.MethodGet {
+		scope = auth.ScopeRead
 	}
-	if strings.TrimPrefix(token, "Bearer ") != h.authToken {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if !authorize(w, r, scope) {
+		return
+	}
+
+	workDir, err := sessionWorkDir(r, h.workDir, h.sessionStore, h.roots)
+	if err != nil {
+		workDirError(w, err)
 		return
 	}
 
@@ -67,18 +88,18 @@ func (h *GitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	switch {
 	case path == "/status" && r.Method == http.MethodGet:
-		h.handleStatus(w, r)
+		h.handleStatus(w, r, workDir)
 	case path == "/diff" && r.Method == http.MethodGet:
-		h.handleDiff(w, r)
+		h.handleDiff(w, r, workDir)
 	default:
 		http.Error(w, "Not found", http.StatusNotFound)
 	}
 }
 
 // handleStatus returns git status
-func (h *GitHandler) handleStatus(w http.ResponseWriter, r *http.Request) {
+func (h *GitHandler) handleStatus(w http.ResponseWriter, r *http.Request, workDir string) {
 	response := StatusResponse{
-		IsRepo: h.isGitRepo(),
+		IsRepo: h.isGitRepo(workDir),
 	}
 
 	if !response.IsRepo {
@@ -88,12 +109,13 @@ func (h *GitHandler) handleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get current branch
-	branch, _ := h.runGit("rev-parse", "--abbrev-ref", "HEAD")
+	branch, _ := h.runGit(workDir, "rev-parse", "--abbrev-ref", "HEAD")
 	response.Branch = strings.TrimSpace(branch)
 
 	// Get status
-	status, _ := h.runGit("status", "--porcelain")
-	lines := strings.Split(strings.TrimSpace(status), "\n")
+	status, _ := h.runGit(workDir, append([]string{"status", "--porcelain"}, excludeState...)...)
+	// The first column is significant, so only the trailing newline is trimmed
+	lines := strings.Split(strings.TrimRight(status, "\n"), "\n")
 
 	for _, line := range lines {
 		if len(line) < 3 {
@@ -127,28 +149,28 @@ func (h *GitHandler) handleStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleDiff returns git diff
-func (h *GitHandler) handleDiff(w http.ResponseWriter, r *http.Request) {
+func (h *GitHandler) handleDiff(w http.ResponseWriter, r *http.Request, workDir string) {
 	response := DiffResponse{}
 
-	if !h.isGitRepo() {
+	if !h.isGitRepo(workDir) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
 	// Get current branch
-	branch, _ := h.runGit("rev-parse", "--abbrev-ref", "HEAD")
+	branch, _ := h.runGit(workDir, "rev-parse", "--abbrev-ref", "HEAD")
 	response.Branch = strings.TrimSpace(branch)
 
 	// Get unstaged diff
-	diff, _ := h.runGit("diff")
+	diff, _ := h.runGit(workDir, append([]string{"diff"}, excludeState...)...)
 	response.Diff = diff
-	response.Files = h.parseDiffStat(false)
+	response.Files = h.parseDiffStat(workDir, false)
 
 	// Get staged diff
-	stagedDiff, _ := h.runGit("diff", "--cached")
+	stagedDiff, _ := h.runGit(workDir, append([]string{"diff", "--cached"}, excludeState...)...)
 	response.StagedDiff = stagedDiff
-	response.Staged = h.parseDiffStat(true)
+	response.Staged = h.parseDiffStat(workDir, true)
 
 	response.HasChanges = len(response.Files) > 0 || len(response.Staged) > 0
 
@@ -157,7 +179,7 @@ func (h *GitHandler) handleDiff(w http.ResponseWriter, r *http.Request) {
 }
 
 // parseDiffStat parses diff --stat output
-func (h *GitHandler) parseDiffStat(staged bool) []DiffFile {
+func (h *GitHandler) parseDiffStat(workDir string, staged bool) []DiffFile {
 	var args []string
 	if staged {
 		args = []string{"diff", "--cached", "--numstat"}
@@ -165,7 +187,7 @@ func (h *GitHandler) parseDiffStat(staged bool) []DiffFile {
 		args = []string{"diff", "--numstat"}
 	}
 
-	output, err := h.runGit(args...)
+	output, err := h.runGit(workDir, append(args, excludeState...)...)
 	if err != nil {
 		return nil
 	}
@@ -223,15 +245,15 @@ func (h *GitHandler) parseDiffStat(staged bool) []DiffFile {
 }
 
 // isGitRepo checks if the work directory is a git repository
-func (h *GitHandler) isGitRepo() bool {
-	_, err := h.runGit("rev-parse", "--git-dir")
+func (h *GitHandler) isGitRepo(workDir string) bool {
+	_, err := h.runGit(workDir, "rev-parse", "--git-dir")
 	return err == nil
 }
 
-// runGit runs a git command and returns the output
-func (h *GitHandler) runGit(args ...string) (string, error) {
+// runGit runs a git command in workDir and returns the output
+func (h *GitHandler) runGit(workDir string, args ...string) (string, error) {
 	cmd := exec.Command("git", args...)
-	cmd.Dir = h.workDir
+	cmd.Dir = workDir
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
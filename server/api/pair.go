@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/Noon-R/Devport/server/auth"
+)
+
+// PairHandler exchanges the pairing code from the startup QR for a device
+// token. The code is the credential, so no token is required.
+type PairHandler struct {
+	tokens *auth.Store
+}
+
+// NewPairHandler creates a new pairing handler
+func NewPairHandler(tokens *auth.Store) *PairHandler {
+	return &PairHandler{tokens: tokens}
+}
+
+// ServeHTTP implements http.Handler
+//
+// POST /api/pair {"code": "ABCD-EFGH", "device_name": "iPhone"} returns the
+// device token and its secret
+func (h *PairHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Code       string `json:"code"`
+		DeviceName string `json:"device_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, secret, err := h.tokens.Pair(req.Code, req.DeviceName)
+	if errors.Is(err, auth.ErrInvalidCode) {
+		auth.ClientFrom(r.Context()).Fail("POST /api/pair", err)
+		http.Error(w, "Invalid or expired pairing code", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, auth.ErrPairingPaused) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auth.ClientFrom(r.Context()).Succeed()
+	log.Printf("Device %q paired", token.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":  token,
+		"secret": secret,
+	})
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Noon-R/Devport/server/stream"
+)
+
+// Server-Sent Events stream settings
+const (
+	sseKeepAlive  = 15 * time.Second // comment sent on idle streams so proxies keep them open
+	sseRetry      = 3 * time.Second  // reconnect delay suggested to EventSource
+	sseBufferSize = 256              // events queued for a slow client before the stream is closed
+)
+
+// handleEvents streams a session's chat.* notifications as Server-Sent
+// Events, for clients behind proxies that break WebSocket. Each event's id
+// is its event_seq, so a reconnecting EventSource resumes from
+// Last-Event-ID. The first event, stream.open, reports the replay like the
+// chat.resume result.
+func (h *ChatHandler) handleEvents(w http.ResponseWriter, r *http.Request, sessionID string) {
+	if h.streams == nil {
+		http.Error(w, "Streaming not available", http.StatusServiceUnavailable)
+		return
+	}
+	if h.sessionStore.Get(sessionID) == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// EventSource sends Last-Event-ID itself; the query is for other clients
+	after := int64(-1)
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	if lastID != "" {
+		seq, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || seq < 0 {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		after = seq
+	}
+
+	// An open stream keeps the process alive like an attached WebSocket
+	if _, err := h.processManager.GetOrCreate(r.Context(), sessionID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer h.processManager.Release(sessionID)
+
+	queue := make(chan stream.Event, sseBufferSize)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+	replay, complete, unsubscribe := h.streams.SubscribeFrom(sessionID, after, func(event stream.Event) {
+		select {
+		case queue <- event:
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	writeSSE(w, 0, "stream.open", map[string]interface{}{
+		"session_id": sessionID,
+		"replayed":   len(replay),
+		"complete":   complete,
+		"event_seq":  h.streams.LastSeq(sessionID),
+	})
+	for _, event := range replay {
+		writeSSE(w, event.Seq, event.Method, event.Params)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-overflow:
+			// The client reconnects and resumes after the last event it got
+			log.Printf("SSE client for session %s too slow, closing stream", sessionID)
+			return
+		case event := <-queue:
+			writeSSE(w, event.Seq, event.Method, event.Params)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSE writes one event. An id of 0 leaves the client's last event ID
+// unchanged.
+func writeSSE(w http.ResponseWriter, id int64, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("SSE marshal %s error: %v", event, err)
+		return
+	}
+	if id > 0 {
+		fmt.Fprintf(w, "id: %d\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
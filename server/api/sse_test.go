@@ -0,0 +1,174 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noon-R/Devport/server/process"
+	"github.com/Noon-R/Devport/server/session"
+	"github.com/Noon-R/Devport/server/stream"
+)
+
+// sseEvent is one event read from a stream
+type sseEvent struct {
+	id, event string
+	data      map[string]interface{}
+}
+
+// sseServer serves the event stream of a session created in a fresh store
+func sseServer(t *testing.T, bufferSize int) (*httptest.Server, *stream.Hub, string) {
+	t.Helper()
+	store := session.NewStore(t.TempDir())
+	sess := store.Create("Streamed", "")
+	handler := NewChatHandler(store, process.NewManager(t.TempDir(), time.Minute))
+	streams := stream.NewHub(bufferSize)
+	handler.SetStreams(streams)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.handleEvents(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	t.Cleanup(server.Close)
+	return server, streams, sess.ID
+}
+
+// openSSE connects to the stream of a session, with lastEventID sent as
+// Last-Event-ID unless empty
+func openSSE(t *testing.T, url, lastEventID string) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %s", ct)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// readSSE reads the next event, skipping retry hints and comments
+func readSSE(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var event sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if event.event != "" {
+				return event
+			}
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.data); err != nil {
+				t.Fatalf("Invalid data %q: %v", line, err)
+			}
+		}
+	}
+}
+
+func TestSSEReplay(t *testing.T) {
+	tests := []struct {
+		name         string
+		lastEventID  string // sent as the header
+		query        string
+		bufferSize   int
+		wantIDs      []string
+		wantComplete bool
+	}{
+		{"no Last-Event-ID", "", "", 10, nil, false},
+		{"Last-Event-ID", "1", "", 10, []string{"2", "3"}, true},
+		{"latest", "3", "", 10, nil, true},
+		{"query", "", "?last_event_id=2", 10, []string{"3"}, true},
+		{"header wins over query", "2", "?last_event_id=0", 10, []string{"3"}, true},
+		{"older than the buffer", "0", "", 2, []string{"2", "3"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, streams, sessionID := sseServer(t, tt.bufferSize)
+			for i := 0; i < 3; i++ {
+				streams.Publish(sessionID, "chat.text", map[string]interface{}{"session_id": sessionID, "content": "x"})
+			}
+
+			r := openSSE(t, server.URL+"/"+sessionID+tt.query, tt.lastEventID)
+			open := readSSE(t, r)
+			if open.event != "stream.open" || open.id != "" {
+				t.Fatalf("Expected stream.open without an id, got %+v", open)
+			}
+			if open.data["replayed"] != float64(len(tt.wantIDs)) || open.data["event_seq"] != float64(3) {
+				t.Errorf("Unexpected stream.open data: %v", open.data)
+			}
+			if tt.lastEventID != "" || tt.query != "" {
+				if open.data["complete"] != tt.wantComplete {
+					t.Errorf("Expected complete %v, got %v", tt.wantComplete, open.data["complete"])
+				}
+			}
+			for _, id := range tt.wantIDs {
+				if event := readSSE(t, r); event.id != id || event.event != "chat.text" {
+					t.Fatalf("Expected replayed event %s, got %+v", id, event)
+				}
+			}
+
+			// Live events follow the replay
+			streams.Publish(sessionID, "chat.done", map[string]interface{}{"session_id": sessionID})
+			if event := readSSE(t, r); event.id != "4" || event.event != "chat.done" || event.data["session_id"] != sessionID {
+				t.Errorf("Expected live event 4, got %+v", event)
+			}
+		})
+	}
+}
+
+func TestSSEErrors(t *testing.T) {
+	server, _, sessionID := sseServer(t, 10)
+	tests := []struct {
+		name, path, lastEventID string
+		want                    int
+	}{
+		{"unknown session", "/missing", "", http.StatusNotFound},
+		{"invalid Last-Event-ID", "/" + sessionID, "abc", http.StatusBadRequest},
+		{"negative Last-Event-ID", "/" + sessionID, "-1", http.StatusBadRequest},
+		{"invalid query", "/" + sessionID + "?last_event_id=x", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+
+	// Without a hub there is nothing to stream
+	handler := NewChatHandler(session.NewStore(t.TempDir()), process.NewManager(t.TempDir(), time.Minute))
+	w := httptest.NewRecorder()
+	handler.handleEvents(w, httptest.NewRequest(http.MethodGet, "/", nil), sessionID)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a hub, got %d", w.Code)
+	}
+}
@@ -0,0 +1,342 @@
+// Package auth manages named API tokens and the scopes they grant
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes a token can carry
+const (
+	ScopeRead     = "read"      // list and read sessions, files and git state
+	ScopeChat     = "chat"      // create sessions and talk to the agent
+	ScopeFSWrite  = "fs-write"  // write and delete files
+	ScopeGitWrite = "git-write" // change the git repository
+	ScopeAdmin    = "admin"     // manage tokens and run maintenance
+)
+
+// AllScopes are granted to the server token (AUTH_TOKEN)
+var AllScopes = []string{ScopeRead, ScopeChat, ScopeFSWrite, ScopeGitWrite, ScopeAdmin}
+
+// MasterTokenID identifies the server token in audit logs and connection state
+const MasterTokenID = "master"
+
+// secretPrefix marks named token secrets so they are recognizable in configs
+const secretPrefix = "dpt_"
+
+// lastUsedSaveInterval limits how often last-used times are written to disk
+const lastUsedSaveInterval = time.Minute
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpired      = errors.New("token expired")
+	ErrRevoked      = errors.New("token revoked")
+	ErrNotFound     = errors.New("token not found")
+)
+
+// Token describes a named API token. Its secret is only returned when the
+// token is created; the store keeps a hash.
+type Token struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Device     bool       `json:"device,omitempty"` // issued by pairing
+}
+
+// Has reports whether the token grants scope
+func (t *Token) Has(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// Expired reports whether the token has expired at now
+func (t *Token) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// record is a token as stored on disk
+type record struct {
+	Token
+	Hash string `json:"hash"`
+
+	savedLastUsed time.Time
+}
+
+// Store holds the named tokens and the server token
+type Store struct {
+	path   string // empty keeps tokens in memory
+	master string
+
+	mu     sync.Mutex
+	tokens map[string]*record // by ID
+	byHash map[string]*record
+
+	// Pending pairing codes and their expiry, see pairing.go
+	codes        map[string]time.Time
+	pairFailures int       // wrong codes in the current window
+	pairWindow   time.Time // start of the window
+
+	// Signs access tokens; refresh tokens and tickets by hash, see session.go
+	signingKey []byte
+	refresh    map[string]grant
+	tickets    map[string]grant
+}
+
+// NewStore loads the named tokens from path, creating an empty store if the
+// file does not exist. masterToken (AUTH_TOKEN) is always accepted with
+// every scope; an empty path keeps named tokens in memory only.
+func NewStore(path, masterToken string) (*Store, error) {
+	s := &Store{
+		path:   path,
+		master: masterToken,
+		tokens: make(map[string]*record),
+		byHash: make(map[string]*record),
+		codes:  make(map[string]time.Time),
+
+		signingKey: make([]byte, 32),
+		refresh:    make(map[string]grant),
+		tickets:    make(map[string]grant),
+	}
+	if _, err := rand.Read(s.signingKey); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, r := range records {
+		if r.LastUsedAt != nil {
+			r.savedLastUsed = *r.LastUsedAt
+		}
+		s.tokens[r.ID] = r
+		s.byHash[r.Hash] = r
+	}
+	return s, nil
+}
+
+// Static returns an in-memory store accepting only the server token
+func Static(masterToken string) *Store {
+	s, _ := NewStore("", masterToken)
+	return s
+}
+
+// Authenticate returns the token a secret or access token belongs to. The
+// server token yields a token with ID MasterTokenID and every scope.
+func (s *Store) Authenticate(secret string) (*Token, error) {
+	if secret == "" {
+		return nil, ErrInvalidToken
+	}
+	if s.master != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(s.master)) == 1 {
+		return masterToken(), nil
+	}
+	if strings.HasPrefix(secret, accessPrefix) {
+		return s.verifyAccess(secret)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.byHash[hash(secret)]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return s.use(r)
+}
+
+// lookup returns the token with the given ID, which must still be valid;
+// the caller holds s.mu
+func (s *Store) lookup(id string) (*Token, error) {
+	if id == MasterTokenID {
+		if s.master == "" {
+			return nil, ErrInvalidToken
+		}
+		return masterToken(), nil
+	}
+	r, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return s.use(r)
+}
+
+// use checks that a named token is valid and records its use; the caller
+// holds s.mu
+func (s *Store) use(r *record) (*Token, error) {
+	now := time.Now()
+	if r.RevokedAt != nil {
+		return nil, ErrRevoked
+	}
+	if r.Expired(now) {
+		return nil, ErrExpired
+	}
+
+	r.LastUsedAt = &now
+	if now.Sub(r.savedLastUsed) >= lastUsedSaveInterval {
+		r.savedLastUsed = now
+		if err := s.save(); err != nil {
+			log.Printf("Failed to save tokens: %v", err)
+		}
+	}
+
+	token := r.Token
+	return &token, nil
+}
+
+func masterToken() *Token {
+	return &Token{ID: MasterTokenID, Name: "server token", Scopes: AllScopes}
+}
+
+// Create adds a named token with the given scopes, expiring after ttl (never
+// if zero). It returns the token and its secret, which cannot be retrieved
+// later.
+func (s *Store) Create(name string, scopes []string, ttl time.Duration) (*Token, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.create(name, scopes, ttl, false)
+}
+
+// create adds a token; the caller holds s.mu
+func (s *Store) create(name string, scopes []string, ttl time.Duration, device bool) (*Token, string, error) {
+	if name == "" {
+		return nil, "", errors.New("token name is required")
+	}
+	if len(scopes) == 0 {
+		return nil, "", errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(AllScopes, scope) {
+			return nil, "", fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+
+	secret, err := randomSecret(secretPrefix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	r := &record{
+		Token: Token{
+			ID:        uuid.New().String(),
+			Name:      name,
+			Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+			CreatedAt: now,
+			Device:    device,
+		},
+		Hash: hash(secret),
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		r.ExpiresAt = &expires
+	}
+
+	s.tokens[r.ID] = r
+	s.byHash[r.Hash] = r
+	if err := s.save(); err != nil {
+		delete(s.tokens, r.ID)
+		delete(s.byHash, r.Hash)
+		return nil, "", err
+	}
+
+	token := r.Token
+	return &token, secret, nil
+}
+
+// List returns the named tokens, oldest first
+func (s *Store) List() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make([]Token, 0, len(s.tokens))
+	for _, r := range s.tokens {
+		tokens = append(tokens, r.Token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+// Revoke permanently disables a named token
+func (s *Store) Revoke(id string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if r.RevokedAt == nil {
+		now := time.Now()
+		r.RevokedAt = &now
+		if err := s.save(); err != nil {
+			r.RevokedAt = nil
+			return nil, err
+		}
+	}
+
+	token := r.Token
+	return &token, nil
+}
+
+// save writes the tokens to disk; the caller holds s.mu
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	records := make([]*record, 0, len(s.tokens))
+	for _, r := range s.tokens {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
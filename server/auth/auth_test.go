@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	store := Static("master")
+	_, valid, err := store.Create("valid", []string{ScopeRead}, 0)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	expiring, expired, err := store.Create("expired", []string{ScopeRead}, time.Hour)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	past := time.Now().Add(-time.Second)
+	store.tokens[expiring.ID].ExpiresAt = &past
+	revokedToken, revoked, err := store.Create("revoked", []string{ScopeRead}, 0)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := store.Revoke(revokedToken.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		secret  string
+		wantID  string
+		wantErr error
+	}{
+		{"master", "master", MasterTokenID, nil},
+		{"named", valid, "", nil},
+		{"expired", expired, "", ErrExpired},
+		{"revoked", revoked, "", ErrRevoked},
+		{"unknown", secretPrefix + "unknown", "", ErrInvalidToken},
+		{"master prefix", "maste", "", ErrInvalidToken},
+		{"empty", "", "", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := store.Authenticate(tt.secret)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate failed: %v", err)
+			}
+			if tt.wantID != "" && token.ID != tt.wantID {
+				t.Errorf("Expected token %s, got %s", tt.wantID, token.ID)
+			}
+			if token.LastUsedAt == nil && token.ID != MasterTokenID {
+				t.Error("Expected the use to be recorded")
+			}
+		})
+	}
+
+	// Without a server token only named tokens are accepted
+	if _, err := Static("").Authenticate(""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for an empty secret, got %v", err)
+	}
+}
+
+func TestTokenHas(t *testing.T) {
+	token := &Token{Scopes: []string{ScopeChat, ScopeRead}}
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{ScopeRead, true},
+		{ScopeChat, true},
+		{ScopeFSWrite, false},
+		{ScopeAdmin, false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := token.Has(tt.scope); got != tt.want {
+			t.Errorf("Has(%q) = %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+	for _, scope := range AllScopes {
+		if !masterToken().Has(scope) {
+			t.Errorf("Expected the server token to have %s", scope)
+		}
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	store := Static("master")
+	tests := []struct {
+		name   string
+		token  string
+		scopes []string
+	}{
+		{"no name", "", []string{ScopeRead}},
+		{"no scopes", "ci", nil},
+		{"unknown scope", "ci", []string{ScopeRead, "root"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := store.Create(tt.token, tt.scopes, 0); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+
+	token, secret, err := store.Create("ci", []string{ScopeRead, ScopeChat, ScopeRead}, 0)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !slices.Equal(token.Scopes, []string{ScopeChat, ScopeRead}) {
+		t.Errorf("Expected sorted unique scopes, got %v", token.Scopes)
+	}
+	if !strings.HasPrefix(secret, secretPrefix) {
+		t.Errorf("Expected a %s secret, got %q", secretPrefix, secret)
+	}
+	if len(store.List()) != 1 {
+		t.Errorf("Expected 1 token, got %d", len(store.List()))
+	}
+}
+
+func TestTokensFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth", "tokens.json")
+	store, err := NewStore(path, "master")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	kept, keptSecret, err := store.Create("kept", []string{ScopeRead}, time.Hour)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	dropped, droppedSecret, err := store.Create("dropped", []string{ScopeChat}, 0)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := store.Revoke(dropped.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Tokens file not written: %v", err)
+	}
+	if strings.Contains(string(data), keptSecret) || strings.Contains(string(data), droppedSecret) {
+		t.Error("Tokens file contains a secret")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	reloaded, err := NewStore(path, "master")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	token, err := reloaded.Authenticate(keptSecret)
+	if err != nil {
+		t.Fatalf("Authenticate failed after reload: %v", err)
+	}
+	if token.ID != kept.ID || token.ExpiresAt == nil || !token.ExpiresAt.Equal(*kept.ExpiresAt) {
+		t.Errorf("Token changed across reload: %+v", token)
+	}
+	if _, err := reloaded.Authenticate(droppedSecret); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Expected the revocation to persist, got %v", err)
+	}
+	if _, err := reloaded.Revoke("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStore(path, "master"); err == nil {
+		t.Error("Expected an error for a corrupt tokens file")
+	}
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lockout policy for failed authentication, per client IP
+const (
+	freeAttempts = 5 // failures before the first lockout
+	baseLockout  = time.Second
+	maxLockout   = 15 * time.Minute
+	// Failure counts are forgotten after this long without a failure
+	forgetFailures = 24 * time.Hour
+)
+
+// Guard is the single entry point for authentication. It authenticates
+// HTTP and WebSocket requests, counts failures per client IP, locks out
+// clients that keep failing for exponentially growing periods, and writes
+// every failure to an audit log. A successful authentication resets the
+// count, and it is forgotten once the client has not failed for
+// forgetFailures. Expired tokens are not counted: clients present them in
+// the normal course of things before refreshing.
+type Guard struct {
+	tokens      *Store
+	trustProxy  bool
+	ticketRoute func(r *http.Request) bool // nil accepts tickets nowhere
+
+	mu      sync.Mutex
+	clients map[string]*failures
+	audit   *os.File // nil logs failures only to the server log
+}
+
+// failures tracks the failed attempts of one client IP
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// auditEntry is a line of the audit log
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	IP       string    `json:"ip"`
+	Event    string    `json:"event"`  // "auth_failed" or "locked_out"
+	Action   string    `json:"action"` // request or RPC method
+	Error    string    `json:"error,omitempty"`
+	Failures int       `json:"failures"`
+	Until    string    `json:"locked_until,omitempty"`
+}
+
+// NewGuard creates a guard authenticating against tokens. Failures are
+// appended to auditPath as JSON lines unless it is empty. With trustProxy
+// the client IP is taken from X-Forwarded-For.
+func NewGuard(tokens *Store, auditPath string, trustProxy bool) (*Guard, error) {
+	g := &Guard{
+		tokens:     tokens,
+		trustProxy: trustProxy,
+		clients:    make(map[string]*failures),
+	}
+	if auditPath != "" {
+		if err := os.MkdirAll(filepath.Dir(auditPath), 0700); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(auditPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		g.audit = f
+	}
+	return g, nil
+}
+
+// SetTicketRoutes sets the requests that may authenticate with a ticket
+// parameter, such as WebSocket upgrades and downloads that clients open
+// without headers. Elsewhere the parameter is ignored and not redeemed.
+func (g *Guard) SetTicketRoutes(allow func(r *http.Request) bool) {
+	g.ticketRoute = allow
+}
+
+// Close closes the audit log
+func (g *Guard) Close() error {
+	if g.audit == nil {
+		return nil
+	}
+	return g.audit.Close()
+}
+
+// Client is the authentication state of a request, available to handlers
+// through ClientFrom
+type Client struct {
+	IP    string
+	Token *Token // nil if the request carried no credentials
+
+	guard *Guard
+}
+
+type clientKey struct{}
+
+// ClientFrom returns the client of a request that went through the guard's
+// middleware, or nil
+func ClientFrom(ctx context.Context) *Client {
+	c, _ := ctx.Value(clientKey{}).(*Client)
+	return c
+}
+
+// TokenFrom returns the token the request was authenticated with, or nil
+func TokenFrom(ctx context.Context) *Token {
+	if c := ClientFrom(ctx); c != nil {
+		return c.Token
+	}
+	return nil
+}
+
+// Fail records a failed authentication by the client, such as a wrong
+// secret sent in a request body or an RPC. A nil client ignores it.
+func (c *Client) Fail(action string, err error) {
+	if c != nil {
+		c.guard.fail(c.IP, action, err)
+	}
+}
+
+// Succeed resets the client's failures after it authenticated, such as by
+// an RPC or a login with a secret in the request body. A nil client ignores
+// it.
+func (c *Client) Succeed() {
+	if c != nil {
+		c.guard.succeed(c.IP)
+	}
+}
+
+// LockedFor returns how long the client is still locked out, or 0
+func (c *Client) LockedFor() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.guard.lockedFor(c.IP)
+}
+
+// Middleware authenticates requests before they reach next. Requests from
+// locked out clients get 429. A request carrying an Authorization header,
+// or a single-use ticket parameter on a ticket route, gets 401 unless the
+// credential is valid; secrets are never read from the URL, where they
+// would end up in proxy and relay logs. Requests without credentials pass
+// through, and handlers check the token with TokenFrom.
+func (g *Guard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := &Client{IP: g.clientIP(r), guard: g}
+		action := r.Method + " " + r.URL.Path
+
+		if wait := g.lockedFor(client.IP); wait > 0 {
+			w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
+
+		var err error
+		if ticket := r.URL.Query().Get("ticket"); ticket != "" && g.ticketRoute != nil && g.ticketRoute(r) {
+			client.Token, err = g.tokens.RedeemTicket(ticket)
+		} else if header := r.Header.Get("Authorization"); header != "" {
+			client.Token, err = g.tokens.Authenticate(strings.TrimPrefix(header, "Bearer "))
+		}
+		if errors.Is(err, ErrExpired) {
+			http.Error(w, "Token expired", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			g.fail(client.IP, action, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if client.Token != nil {
+			g.succeed(client.IP)
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, client)))
+	})
+}
+
+// clientIP returns the IP a request came from. Behind a trusted proxy it is
+// the rightmost X-Forwarded-For entry, the one the proxy appended; entries
+// left of it come from the client and can be forged.
+func (g *Guard) clientIP(r *http.Request) string {
+	if g.trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			last := forwarded[len(forwarded)-1]
+			if i := strings.LastIndex(last, ","); i >= 0 {
+				last = last[i+1:]
+			}
+			if ip := strings.TrimSpace(last); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (g *Guard) lockedFor(ip string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f, ok := g.clients[ip]
+	if !ok {
+		return 0
+	}
+	return max(time.Until(f.lockedUntil), 0)
+}
+
+// fail counts a failure and locks the client out once it has used up its
+// free attempts, doubling the lockout with every further failure
+func (g *Guard) fail(ip, action string, err error) {
+	if errors.Is(err, ErrExpired) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.prune(now)
+	f, ok := g.clients[ip]
+	if !ok {
+		f = &failures{}
+		g.clients[ip] = f
+	}
+	f.count++
+	f.last = now
+
+	entry := auditEntry{
+		Time:     now,
+		IP:       ip,
+		Event:    "auth_failed",
+		Action:   action,
+		Error:    err.Error(),
+		Failures: f.count,
+	}
+	if f.count >= freeAttempts {
+		lockout := min(baseLockout<<min(f.count-freeAttempts, 20), maxLockout)
+		f.lockedUntil = now.Add(lockout)
+		entry.Event = "locked_out"
+		entry.Until = f.lockedUntil.Format(time.RFC3339)
+		log.Printf("Locked out %s for %v after %d failed attempts", ip, lockout, f.count)
+	} else {
+		log.Printf("Authentication failed from %s (%s): %v", ip, action, err)
+	}
+	g.writeAudit(entry)
+}
+
+func (g *Guard) succeed(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.clients, ip)
+}
+
+// prune forgets clients that have not failed for a while; the caller holds
+// g.mu
+func (g *Guard) prune(now time.Time) {
+	for ip, f := range g.clients {
+		if now.Sub(f.last) > forgetFailures && !now.Before(f.lockedUntil) {
+			delete(g.clients, ip)
+		}
+	}
+}
+
+// writeAudit appends an entry to the audit log; the caller holds g.mu
+func (g *Guard) writeAudit(entry auditEntry) {
+	if g.audit == nil {
+		return
+	}
+	if err := json.NewEncoder(g.audit).Encode(entry); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
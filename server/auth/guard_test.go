@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// guardedRequest sends a request with a bearer secret through the guard
+func guardedRequest(g *Guard, secret string) *httptest.ResponseRecorder {
+	handler := g.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if TokenFrom(r.Context()) == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/api/fs/", nil)
+	r.Header.Set("Authorization", "Bearer "+secret)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestGuardIgnoresExpiredTokens(t *testing.T) {
+	store := Static("master")
+	guard, err := NewGuard(store, "", false)
+	if err != nil {
+		t.Fatalf("NewGuard failed: %v", err)
+	}
+	expired := forgeAccess(store, accessClaims{Subject: MasterTokenID, Expires: time.Now().Add(-time.Second).Unix()})
+
+	for i := 0; i < 2*freeAttempts; i++ {
+		w := guardedRequest(guard, expired)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "expired") {
+			t.Fatalf("Attempt %d: expected 401 Token expired, got %d %s", i+1, w.Code, w.Body)
+		}
+	}
+	if w := guardedRequest(guard, "master"); w.Code != http.StatusOK {
+		t.Errorf("Expected expired tokens not to lock the client out, got %d", w.Code)
+	}
+}
+
+func TestGuardSuccessResetsFailures(t *testing.T) {
+	guard, err := NewGuard(Static("master"), "", false)
+	if err != nil {
+		t.Fatalf("NewGuard failed: %v", err)
+	}
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < freeAttempts-1; i++ {
+			if w := guardedRequest(guard, "wrong"); w.Code != http.StatusUnauthorized {
+				t.Fatalf("Round %d: expected 401, got %d", round, w.Code)
+			}
+		}
+		if w := guardedRequest(guard, "master"); w.Code != http.StatusOK {
+			t.Fatalf("Round %d: expected the valid secret to pass, got %d", round, w.Code)
+		}
+	}
+
+	// Without a success in between the fifth failure locks the client out
+	for i := 0; i < freeAttempts; i++ {
+		guardedRequest(guard, "wrong")
+	}
+	if w := guardedRequest(guard, "master"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a lockout, got %d", w.Code)
+	}
+
+	// Logins in a request body or RPC reset the count through the client
+	client := &Client{IP: "198.51.100.7", guard: guard}
+	for i := 0; i < freeAttempts-1; i++ {
+		client.Fail("ws auth", ErrInvalidToken)
+	}
+	client.Succeed()
+	client.Fail("ws auth", ErrInvalidToken)
+	if client.LockedFor() != 0 {
+		t.Error("Expected Succeed to reset the failures")
+	}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// Pairing codes are short enough to type, and only valid once for a few
+// minutes. Scanning the startup QR exchanges one for a device token.
+const (
+	pairingAlphabet   = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no 0/O or 1/I
+	pairingCodeLength = 8
+	// Wrong codes accepted from all clients per window; beyond that pairing
+	// is paused until the window ends. Pending codes are kept, so nobody can
+	// invalidate the owner's QR by guessing; single clients are locked out
+	// earlier by the guard.
+	maxPairingFailures   = 10
+	pairingFailureWindow = time.Minute
+	// Used when no lifetime is configured
+	defaultPairingCodeTTL = 10 * time.Minute
+)
+
+// DeviceScopes are granted to paired devices: everything but token management
+var DeviceScopes = []string{ScopeRead, ScopeChat, ScopeFSWrite, ScopeGitWrite}
+
+var (
+	ErrInvalidCode = errors.New("invalid or expired pairing code")
+	// ErrPairingPaused is returned while too many wrong codes were tried
+	ErrPairingPaused = errors.New("too many invalid pairing codes; try again later")
+)
+
+// NewPairingCode returns a single-use code valid for ttl, formatted as
+// XXXX-XXXX
+func (s *Store) NewPairingCode(ttl time.Duration) (string, time.Time, error) {
+	if ttl <= 0 {
+		ttl = defaultPairingCodeTTL
+	}
+	buf := make([]byte, pairingCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	// 256 is a multiple of the alphabet size, so this is unbiased
+	for i, b := range buf {
+		buf[i] = pairingAlphabet[int(b)%len(pairingAlphabet)]
+	}
+	code := string(buf)
+	expires := time.Now().Add(ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneCodes()
+	s.codes[code] = expires
+	return code[:4] + "-" + code[4:], expires, nil
+}
+
+// Pair consumes a pairing code and creates a token for the device
+func (s *Store) Pair(code, deviceName string) (*Token, string, error) {
+	code = normalizeCode(code)
+	deviceName = strings.TrimSpace(deviceName)
+	if deviceName == "" {
+		deviceName = "device"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.pairWindow) >= pairingFailureWindow {
+		s.pairWindow = now
+		s.pairFailures = 0
+	}
+	if s.pairFailures >= maxPairingFailures {
+		return nil, "", ErrPairingPaused
+	}
+
+	s.pruneCodes()
+	if _, ok := s.codes[code]; !ok {
+		s.pairFailures++
+		if s.pairFailures == maxPairingFailures {
+			log.Printf("Too many invalid pairing codes, pausing pairing for %v", pairingFailureWindow-now.Sub(s.pairWindow))
+		}
+		return nil, "", ErrInvalidCode
+	}
+	delete(s.codes, code)
+
+	return s.create(deviceName, DeviceScopes, 0, true)
+}
+
+// Devices returns the paired devices, oldest first
+func (s *Store) Devices() []Token {
+	var devices []Token
+	for _, token := range s.List() {
+		if token.Device {
+			devices = append(devices, token)
+		}
+	}
+	return devices
+}
+
+// pruneCodes drops expired codes; the caller holds s.mu
+func (s *Store) pruneCodes() {
+	now := time.Now()
+	for code, expires := range s.codes {
+		if !now.Before(expires) {
+			delete(s.codes, code)
+		}
+	}
+}
+
+// normalizeCode accepts codes typed in lower case or with separators
+func normalizeCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
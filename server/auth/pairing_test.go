@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPairingCodeSingleUse(t *testing.T) {
+	store := Static("master")
+	code, _, err := store.NewPairingCode(0)
+	if err != nil {
+		t.Fatalf("NewPairingCode failed: %v", err)
+	}
+
+	// Codes are accepted in lower case and without the separator
+	token, secret, err := store.Pair(strings.ToLower(code[:4])+" "+code[5:], "phone")
+	if err != nil {
+		t.Fatalf("Pair failed: %v", err)
+	}
+	if !token.Device || secret == "" {
+		t.Errorf("Expected a device token with a secret, got %+v", token)
+	}
+	if _, _, err := store.Pair(code, "phone"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Expected a reused code to be rejected, got %v", err)
+	}
+}
+
+func TestPairingFailuresKeepCodes(t *testing.T) {
+	store := Static("master")
+	code, _, err := store.NewPairingCode(time.Minute)
+	if err != nil {
+		t.Fatalf("NewPairingCode failed: %v", err)
+	}
+
+	for i := 0; i < maxPairingFailures; i++ {
+		if _, _, err := store.Pair("WRNG-CODE", "attacker"); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("Attempt %d: expected ErrInvalidCode, got %v", i, err)
+		}
+	}
+
+	// Pairing is paused for the rest of the window...
+	if _, _, err := store.Pair(code, "phone"); !errors.Is(err, ErrPairingPaused) {
+		t.Fatalf("Expected ErrPairingPaused, got %v", err)
+	}
+
+	// ...but the pending code survives it
+	store.mu.Lock()
+	store.pairWindow = time.Now().Add(-pairingFailureWindow)
+	store.mu.Unlock()
+	if _, _, err := store.Pair(code, "phone"); err != nil {
+		t.Errorf("Expected the code to work after the pause, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Clients exchange a long-lived secret for a short-lived access token and a
+// refresh token, so that the secret is sent once per connection rather than
+// with every request. Access tokens are signed with a key generated at
+// startup; refresh tokens and tickets are kept in memory, so a restart
+// requires authenticating with the secret again.
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+	// Tickets authenticate one WebSocket upgrade or download URL
+	TicketTTL = 30 * time.Second
+
+	accessPrefix  = "dpa_"
+	refreshPrefix = "dpr_"
+	ticketPrefix  = "dpk_"
+)
+
+// Session is the result of authenticating: an access token for requests
+// and a refresh token to renew it
+type Session struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+}
+
+// grant is a refresh token or ticket, standing in for a token until it
+// expires
+type grant struct {
+	tokenID string
+	expires time.Time
+}
+
+// accessClaims is the signed part of an access token
+type accessClaims struct {
+	Subject string `json:"sub"` // token ID
+	Expires int64  `json:"exp"` // unix seconds
+}
+
+// Issue starts a session for an authenticated token
+func (s *Store) Issue(token *Token) (*Session, error) {
+	refresh, err := randomSecret(refreshPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruneGrants(s.refresh)
+	s.refresh[hash(refresh)] = grant{tokenID: token.ID, expires: time.Now().Add(RefreshTokenTTL)}
+	return &Session{
+		AccessToken:  s.sign(token.ID),
+		RefreshToken: refresh,
+		ExpiresIn:    int(AccessTokenTTL / time.Second),
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new session. Refresh tokens are
+// single-use: the old one is invalid afterwards.
+func (s *Store) Refresh(refresh string) (*Token, *Session, error) {
+	s.mu.Lock()
+	g, ok := s.refresh[hash(refresh)]
+	delete(s.refresh, hash(refresh))
+	var token *Token
+	err := ErrInvalidToken
+	if ok && time.Now().Before(g.expires) {
+		token, err = s.lookup(g.tokenID)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := s.Issue(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, session, nil
+}
+
+// Ticket returns a single-use credential for token that can be put in a
+// URL, where a secret would end up in proxy and relay logs
+func (s *Store) Ticket(token *Token) (string, error) {
+	ticket, err := randomSecret(ticketPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruneGrants(s.tickets)
+	s.tickets[hash(ticket)] = grant{tokenID: token.ID, expires: time.Now().Add(TicketTTL)}
+	return ticket, nil
+}
+
+// RedeemTicket returns the token a ticket was issued for and invalidates it
+func (s *Store) RedeemTicket(ticket string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.tickets[hash(ticket)]
+	delete(s.tickets, hash(ticket))
+	if !ok || !time.Now().Before(g.expires) {
+		return nil, ErrInvalidToken
+	}
+	return s.lookup(g.tokenID)
+}
+
+// sign returns an access token for the token ID
+func (s *Store) sign(tokenID string) string {
+	payload, _ := json.Marshal(accessClaims{
+		Subject: tokenID,
+		Expires: time.Now().Add(AccessTokenTTL).Unix(),
+	})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return accessPrefix + encoded + "." + s.mac(encoded)
+}
+
+// verifyAccess checks an access token's signature and expiry, and that the
+// token it was issued for is still valid
+func (s *Store) verifyAccess(access string) (*Token, error) {
+	encoded, sig, ok := strings.Cut(strings.TrimPrefix(access, accessPrefix), ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.mac(encoded))) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims accessClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return nil, ErrExpired
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookup(claims.Subject)
+}
+
+func (s *Store) mac(data string) string {
+	m := hmac.New(sha256.New, s.signingKey)
+	m.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func randomSecret(prefix string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
+
+// pruneGrants drops expired grants; the caller holds s.mu
+func pruneGrants(grants map[string]grant) {
+	now := time.Now()
+	for key, g := range grants {
+		if !now.Before(g.expires) {
+			delete(grants, key)
+		}
+	}
+}
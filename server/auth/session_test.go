@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// forgeAccess signs an access token with the given claims
+func forgeAccess(s *Store, claims accessClaims) string {
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return accessPrefix + encoded + "." + s.mac(encoded)
+}
+
+// expireGrants moves the expiry of every grant into the past
+func expireGrants(s *Store, grants map[string]grant) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, g := range grants {
+		g.expires = time.Now().Add(-time.Second)
+		grants[key] = g
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	store := Static("master")
+	token, _, err := store.Create("phone", []string{ScopeRead}, 0)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	session, err := store.Issue(token)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		access  string
+		wantErr error
+	}{
+		{"issued", session.AccessToken, nil},
+		{"expired", forgeAccess(store, accessClaims{Subject: token.ID, Expires: time.Now().Add(-time.Second).Unix()}), ErrExpired},
+		{"unknown subject", forgeAccess(store, accessClaims{Subject: "missing", Expires: time.Now().Add(time.Minute).Unix()}), ErrInvalidToken},
+		{"tampered signature", session.AccessToken + "x", ErrInvalidToken},
+		{"other signing key", Static("master").sign(token.ID), ErrInvalidToken},
+		{"no signature", accessPrefix + "e30", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.Authenticate(tt.access)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate failed: %v", err)
+			}
+			if got.ID != token.ID {
+				t.Errorf("Expected token %s, got %s", token.ID, got.ID)
+			}
+		})
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	store := Static("master")
+	session, err := store.Issue(masterToken())
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	token, renewed, err := store.Refresh(session.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if token.ID != MasterTokenID || renewed.RefreshToken == session.RefreshToken {
+		t.Errorf("Unexpected refresh result: %+v, %+v", token, renewed)
+	}
+
+	// Refresh tokens are single-use
+	if _, _, err := store.Refresh(session.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a reused refresh token to be rejected, got %v", err)
+	}
+
+	expireGrants(store, store.refresh)
+	if _, _, err := store.Refresh(renewed.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected an expired refresh token to be rejected, got %v", err)
+	}
+}
+
+func TestTicket(t *testing.T) {
+	store := Static("master")
+	ticket, err := store.Ticket(masterToken())
+	if err != nil {
+		t.Fatalf("Ticket failed: %v", err)
+	}
+	if token, err := store.RedeemTicket(ticket); err != nil || token.ID != MasterTokenID {
+		t.Fatalf("Expected the ticket to redeem, got %v, %v", token, err)
+	}
+	if _, err := store.RedeemTicket(ticket); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a reused ticket to be rejected, got %v", err)
+	}
+
+	expired, err := store.Ticket(masterToken())
+	if err != nil {
+		t.Fatalf("Ticket failed: %v", err)
+	}
+	expireGrants(store, store.tickets)
+	if _, err := store.RedeemTicket(expired); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected an expired ticket to be rejected, got %v", err)
+	}
+
+	// Tickets are not secrets
+	if _, err := store.Authenticate(ticket); err == nil {
+		t.Error("Expected a ticket to be rejected as a secret")
+	}
+}
+
+func TestRevokeEndsSessions(t *testing.T) {
+	store := Static("master")
+	token, _, err := store.Create("phone", []string{ScopeRead}, 0)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	session, err := store.Issue(token)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	ticket, err := store.Ticket(token)
+	if err != nil {
+		t.Fatalf("Ticket failed: %v", err)
+	}
+
+	if _, err := store.Revoke(token.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := store.Authenticate(session.AccessToken); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Expected the access token to be revoked, got %v", err)
+	}
+	if _, _, err := store.Refresh(session.RefreshToken); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Expected the refresh token to be revoked, got %v", err)
+	}
+	if _, err := store.RedeemTicket(ticket); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Expected the ticket to be revoked, got %v", err)
+	}
+}
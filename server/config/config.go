@@ -2,6 +2,12 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
 )
 
 type Config struct {
@@ -12,6 +18,40 @@ type Config struct {
 	DevMode    bool
 	LogLevel   string
 
+	// ProjectRoots are the directories sessions may use as working
+	// directories (defaults to WorkDir)
+	ProjectRoots []string
+
+	// Encryption at rest (a key file takes precedence over a passphrase;
+	// both empty stores sessions as plaintext)
+	EncryptionKeyFile    string
+	EncryptionPassphrase string
+
+	// Retention settings (0 disables a step)
+	RetentionArchiveDays  int
+	RetentionDeleteDays   int
+	ToolOutputCompactDays int
+	ToolOutputMaxBytes    int
+	ToolOutputMode        string // "offload" or "truncate"
+	MaintenanceInterval   time.Duration
+
+	// WebSocket permessage-deflate: "context" (default), "no-context" or "off"
+	WSCompression string
+
+	// WebSocket heartbeat (0 interval disables server pings)
+	HeartbeatInterval time.Duration
+	HeartbeatTimeout  time.Duration
+
+	// Lifetime of the pairing code shown in the startup QR
+	PairingCodeTTL time.Duration
+
+	// Take client IPs for lockout from X-Forwarded-For (behind a proxy)
+	TrustProxy bool
+
+	// Webhooks receiving server events
+	WebhookURLs   []string
+	WebhookSecret string
+
 	// Relay settings
 	RelayEnabled bool
 	RelayURL     string
@@ -28,6 +68,33 @@ func Load() *Config {
 		DevMode:    getEnv("DEV_MODE", "false") == "true",
 		LogLevel:   getEnv("LOG_LEVEL", "info"),
 
+		ProjectRoots: getEnvList("PROJECT_ROOTS"),
+
+		// Encryption at rest
+		EncryptionKeyFile:    getEnv("ENCRYPTION_KEY_FILE", ""),
+		EncryptionPassphrase: getEnv("ENCRYPTION_PASSPHRASE", ""),
+
+		// Retention settings
+		RetentionArchiveDays:  getEnvInt("RETENTION_ARCHIVE_DAYS", 0),
+		RetentionDeleteDays:   getEnvInt("RETENTION_DELETE_DAYS", 0),
+		ToolOutputCompactDays: getEnvInt("TOOL_OUTPUT_COMPACT_DAYS", 0),
+		ToolOutputMaxBytes:    getEnvInt("TOOL_OUTPUT_MAX_BYTES", 64*1024),
+		ToolOutputMode:        getEnv("TOOL_OUTPUT_MODE", "offload"),
+		MaintenanceInterval:   getEnvDuration("MAINTENANCE_INTERVAL", 6*time.Hour),
+
+		// WebSocket
+		WSCompression:     getEnv("WS_COMPRESSION", "context"),
+		HeartbeatInterval: getEnvDuration("HEARTBEAT_INTERVAL", 30*time.Second),
+		HeartbeatTimeout:  getEnvDuration("HEARTBEAT_TIMEOUT", 10*time.Second),
+
+		// Device pairing
+		PairingCodeTTL: getEnvDuration("PAIRING_CODE_TTL", 10*time.Minute),
+		TrustProxy:     getEnv("TRUST_PROXY", "false") == "true",
+
+		// Webhooks
+		WebhookURLs:   getEnvList("WEBHOOK_URLS"),
+		WebhookSecret: getEnv("WEBHOOK_SECRET", ""),
+
 		// Relay settings
 		RelayEnabled: getEnv("RELAY_ENABLED", "true") == "true",
 		RelayURL:     getEnv("RELAY_URL", "https://cloud.devport.app"),
@@ -36,9 +103,70 @@ func Load() *Config {
 	}
 }
 
+// Roots returns the allowed project roots, falling back to WorkDir
+func (c *Config) Roots() []string {
+	if len(c.ProjectRoots) > 0 {
+		return c.ProjectRoots
+	}
+	return []string{c.WorkDir}
+}
+
+// CompressionMode returns the permessage-deflate mode offered on WebSocket
+// connections
+func (c *Config) CompressionMode() websocket.CompressionMode {
+	switch c.WSCompression {
+	case "off":
+		return websocket.CompressionDisabled
+	case "no-context":
+		return websocket.CompressionNoContextTakeover
+	default:
+		return websocket.CompressionContextTakeover
+	}
+}
+
+// TokensPath returns where named API tokens are stored
+func (c *Config) TokensPath() string {
+	return filepath.Join(c.WorkDir, ".devport", "tokens.json")
+}
+
+// AuditLogPath returns where failed authentication attempts are logged
+func (c *Config) AuditLogPath() string {
+	return filepath.Join(c.WorkDir, ".devport", "audit.log")
+}
+
+// EncryptionSaltPath returns where the salt for passphrase-derived keys
+// is stored
+func (c *Config) EncryptionSaltPath() string {
+	return filepath.Join(c.WorkDir, ".devport", "encryption-salt")
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,274 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeySize is the size of key-encryption and data-encryption keys (AES-256)
+const KeySize = 32
+
+// envelopeVersion is the current version of the on-disk envelope format
+const envelopeVersion = 1
+
+// pbkdf2Iterations is the work factor for passphrase-derived keys
+const pbkdf2Iterations = 600000
+
+var (
+	// ErrWrongKey is returned when data was encrypted with a different key
+	ErrWrongKey = errors.New("data is encrypted with a different key")
+	// ErrNotEncrypted is returned when opening data that is not an envelope
+	ErrNotEncrypted = errors.New("data is not encrypted")
+)
+
+// Key is a key-encryption key. Every file is encrypted with its own random
+// data key, which is stored next to the ciphertext wrapped by this key, so
+// rotating the key only rewraps the data keys.
+type Key struct {
+	id  string
+	kek []byte
+}
+
+// envelope is the on-disk format of an encrypted file
+type envelope struct {
+	Version    int    `json:"devport_envelope"`
+	KeyID      string `json:"kid"`
+	WrappedKey string `json:"wrapped_key"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// NewKey wraps raw key material
+func NewKey(kek []byte) (*Key, error) {
+	if len(kek) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(kek))
+	}
+	sum := sha256.Sum256(kek)
+	return &Key{
+		id:  hex.EncodeToString(sum[:8]),
+		kek: kek,
+	}, nil
+}
+
+// ID identifies the key without revealing it
+func (k *Key) ID() string {
+	return k.id
+}
+
+// LoadKeyFile reads a key file containing 32 raw bytes or their hex or
+// base64 encoding. A missing file is created with a new random key.
+func LoadKeyFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return generateKeyFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == KeySize {
+		return NewKey(data)
+	}
+	text := strings.TrimSpace(string(data))
+	if raw, err := hex.DecodeString(text); err == nil {
+		return NewKey(raw)
+	}
+	if raw, err := base64.StdEncoding.DecodeString(text); err == nil {
+		return NewKey(raw)
+	}
+	return nil, fmt.Errorf("key file %s: unrecognized encoding", path)
+}
+
+func generateKeyFile(path string) (*Key, error) {
+	kek := make([]byte, KeySize)
+	if _, err := rand.Read(kek); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(kek)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return NewKey(kek)
+}
+
+// DeriveKey derives a key from a passphrase. The salt is read from
+// saltPath, or generated and written there on first use.
+func DeriveKey(passphrase, saltPath string) (*Key, error) {
+	salt, err := os.ReadFile(saltPath)
+	if errors.Is(err, os.ErrNotExist) {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(saltPath), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(saltPath, salt, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	kek, err := pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iterations, KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(kek)
+}
+
+// LoadKey returns the key configured by a key file or a passphrase, or nil
+// if neither is set. The key file takes precedence.
+func LoadKey(keyFile, passphrase, saltPath string) (*Key, error) {
+	switch {
+	case keyFile != "":
+		return LoadKeyFile(keyFile)
+	case passphrase != "":
+		return DeriveKey(passphrase, saltPath)
+	default:
+		return nil, nil
+	}
+}
+
+// IsEncrypted reports whether data is an encrypted envelope
+func IsEncrypted(data []byte) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return false
+	}
+	var probe struct {
+		Version int `json:"devport_envelope"`
+	}
+	return json.Unmarshal(data, &probe) == nil && probe.Version > 0
+}
+
+// KeyID returns the ID of the key an envelope was sealed with, or "" if
+// data is not an envelope
+func KeyID(data []byte) string {
+	var env envelope
+	if json.Unmarshal(data, &env) != nil || env.Version == 0 {
+		return ""
+	}
+	return env.KeyID
+}
+
+// Seal encrypts plaintext with a fresh data key wrapped by k
+func (k *Key) Seal(plaintext []byte) ([]byte, error) {
+	dek := make([]byte, KeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := seal(dek, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.kek, dek)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelope{
+		Version:    envelopeVersion,
+		KeyID:      k.id,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	})
+}
+
+// Open decrypts an envelope sealed with k
+func (k *Key) Open(data []byte) ([]byte, error) {
+	env, dek, err := k.unwrap(data)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return open(dek, ciphertext)
+}
+
+// Rewrap re-encrypts the data key of an envelope sealed with k under
+// newKey, leaving the ciphertext untouched
+func (k *Key) Rewrap(data []byte, newKey *Key) ([]byte, error) {
+	env, dek, err := k.unwrap(data)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(newKey.kek, dek)
+	if err != nil {
+		return nil, err
+	}
+	env.KeyID = newKey.id
+	env.WrappedKey = base64.StdEncoding.EncodeToString(wrapped)
+	return json.Marshal(env)
+}
+
+func (k *Key) unwrap(data []byte) (*envelope, []byte, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil || env.Version == 0 {
+		return nil, nil, ErrNotEncrypted
+	}
+	if env.Version > envelopeVersion {
+		return nil, nil, fmt.Errorf("unsupported envelope version %d", env.Version)
+	}
+	if env.KeyID != k.id {
+		return nil, nil, ErrWrongKey
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(env.WrappedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	dek, err := open(k.kek, wrapped)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &env, dek, nil
+}
+
+// seal encrypts with AES-256-GCM, prefixing the random nonce
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
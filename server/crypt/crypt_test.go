@@ -0,0 +1,266 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(t *testing.T, b byte) *Key {
+	t.Helper()
+	key, err := NewKey(bytes.Repeat([]byte{b}, KeySize))
+	if err != nil {
+		t.Fatalf("NewKey failed: %v", err)
+	}
+	return key
+}
+
+// tamper flips a bit of a base64 field of an envelope
+func tamper(t *testing.T, data []byte, field func(*envelope) *string) []byte {
+	t.Helper()
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatalf("Invalid envelope: %v", err)
+	}
+	value := field(&env)
+	raw, err := base64.StdEncoding.DecodeString(*value)
+	if err != nil {
+		t.Fatalf("Invalid base64: %v", err)
+	}
+	raw[len(raw)-1] ^= 0x01
+	*value = base64.StdEncoding.EncodeToString(raw)
+	out, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	return out
+}
+
+func TestSealOpen(t *testing.T) {
+	key := testKey(t, 1)
+	for _, plaintext := range [][]byte{nil, []byte(`{"id":"s1"}`), bytes.Repeat([]byte("x"), 1<<16)} {
+		sealed, err := key.Seal(plaintext)
+		if err != nil {
+			t.Fatalf("Seal failed: %v", err)
+		}
+		if !IsEncrypted(sealed) || KeyID(sealed) != key.ID() {
+			t.Errorf("Expected an envelope under key %s, got %.80s", key.ID(), sealed)
+		}
+		opened, err := key.Open(sealed)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Errorf("Expected %d bytes back, got %d", len(plaintext), len(opened))
+		}
+	}
+
+	// Every seal uses a fresh data key and nonce
+	a, _ := key.Seal([]byte("same"))
+	b, _ := key.Seal([]byte("same"))
+	if bytes.Equal(a, b) {
+		t.Error("Sealing twice produced the same envelope")
+	}
+}
+
+func TestOpenFailures(t *testing.T) {
+	key := testKey(t, 1)
+	sealed, err := key.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	// An envelope claiming the key's ID but wrapped by another key
+	forged, err := testKey(t, 2).Seal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	var env envelope
+	json.Unmarshal(forged, &env)
+	env.KeyID = key.ID()
+	forged, _ = json.Marshal(env)
+
+	tests := []struct {
+		name string
+		key  *Key
+		data []byte
+		want error // nil accepts any error
+	}{
+		{"wrong key", testKey(t, 2), sealed, ErrWrongKey},
+		{"forged key ID", key, forged, nil},
+		{"tampered ciphertext", key, tamper(t, sealed, func(e *envelope) *string { return &e.Ciphertext }), nil},
+		{"tampered data key", key, tamper(t, sealed, func(e *envelope) *string { return &e.WrappedKey }), nil},
+		{"plaintext", key, []byte(`{"id":"s1"}`), ErrNotEncrypted},
+		{"newer version", key, []byte(`{"devport_envelope":99}`), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.key.Open(tt.data)
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	oldKey, newKey := testKey(t, 1), testKey(t, 2)
+	sealed, err := oldKey.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	rewrapped, err := oldKey.Rewrap(sealed, newKey)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if KeyID(rewrapped) != newKey.ID() {
+		t.Errorf("Expected key %s, got %s", newKey.ID(), KeyID(rewrapped))
+	}
+	if _, err := oldKey.Open(rewrapped); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey for the old key, got %v", err)
+	}
+	if opened, err := newKey.Open(rewrapped); err != nil || string(opened) != "secret" {
+		t.Errorf("Expected the plaintext under the new key, got %q, %v", opened, err)
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{`{"devport_envelope":1,"kid":"k"}`, true},
+		{`  {"devport_envelope":1}`, true},
+		{`{"id":"s1","title":"t"}`, false},
+		{`[{"devport_envelope":1}]`, false},
+		{`not json`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := IsEncrypted([]byte(tt.data)); got != tt.want {
+			t.Errorf("IsEncrypted(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	raw := bytes.Repeat([]byte{7}, KeySize)
+	want := testKey(t, 7).ID()
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr bool
+	}{
+		{"raw", raw, false},
+		{"hex", []byte(hex.EncodeToString(raw) + "\n"), false},
+		{"base64", []byte(base64.StdEncoding.EncodeToString(raw) + "\n"), false},
+		{"short", []byte(hex.EncodeToString(raw[:8])), true},
+		{"garbage", []byte("not a key"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, tt.content, 0600); err != nil {
+				t.Fatal(err)
+			}
+			key, err := LoadKeyFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadKeyFile failed: %v", err)
+			}
+			if key.ID() != want {
+				t.Errorf("Expected key %s, got %s", want, key.ID())
+			}
+		})
+	}
+}
+
+func TestLoadKeyFileGenerates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "session.key")
+	key, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadKeyFile failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Key file not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	again, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadKeyFile failed: %v", err)
+	}
+	if again.ID() != key.ID() {
+		t.Error("Reloading the generated key file gave a different key")
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	saltPath := filepath.Join(dir, "salt")
+
+	derived, err := LoadKey("", "correct horse", saltPath)
+	if err != nil {
+		t.Fatalf("LoadKey failed: %v", err)
+	}
+	salt, err := os.ReadFile(saltPath)
+	if err != nil || len(salt) != 16 {
+		t.Fatalf("Expected a 16-byte salt, got %d bytes, %v", len(salt), err)
+	}
+
+	// The stored salt makes the derivation repeatable
+	again, err := LoadKey("", "correct horse", saltPath)
+	if err != nil {
+		t.Fatalf("LoadKey failed: %v", err)
+	}
+	if again.ID() != derived.ID() {
+		t.Error("The same passphrase and salt derived a different key")
+	}
+	other, err := LoadKey("", "wrong horse", saltPath)
+	if err != nil {
+		t.Fatalf("LoadKey failed: %v", err)
+	}
+	if other.ID() == derived.ID() {
+		t.Error("A different passphrase derived the same key")
+	}
+	resalted, err := LoadKey("", "correct horse", filepath.Join(dir, "other-salt"))
+	if err != nil {
+		t.Fatalf("LoadKey failed: %v", err)
+	}
+	if resalted.ID() == derived.ID() {
+		t.Error("A different salt derived the same key")
+	}
+
+	// The key file takes precedence over the passphrase
+	keyPath := filepath.Join(dir, "key")
+	os.WriteFile(keyPath, bytes.Repeat([]byte{7}, KeySize), 0600)
+	fromFile, err := LoadKey(keyPath, "correct horse", saltPath)
+	if err != nil {
+		t.Fatalf("LoadKey failed: %v", err)
+	}
+	if fromFile.ID() != testKey(t, 7).ID() {
+		t.Error("Expected the key file to take precedence")
+	}
+
+	if none, err := LoadKey("", "", saltPath); none != nil || err != nil {
+		t.Errorf("Expected no key, got %v, %v", none, err)
+	}
+}
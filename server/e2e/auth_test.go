@@ -0,0 +1,253 @@
+package e2e
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noon-R/Devport/server/auth"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// get sends a GET request with an optional bearer secret
+func get(t *testing.T, url, secret string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestRESTUnauthorized(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"no credentials", ""},
+		{"wrong secret", "wrong-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := get(t, server.URL+"/api/fs/", tt.secret)
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("Expected status 401, got %d", resp.StatusCode)
+			}
+		})
+	}
+
+	resp := get(t, server.URL+"/api/fs/", testToken)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 with the master token, got %d", resp.StatusCode)
+	}
+}
+
+func TestRESTMissingScope(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+
+	_, secret, err := server.tokens.Create("reader", []string{auth.ScopeRead}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	if resp := get(t, server.URL+"/api/fs/", secret); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 for a read, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/fs/file.txt", strings.NewReader(`{"content":"x"}`))
+	req.Header.Set("Authorization", "Bearer "+secret)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a write, got %d", resp.StatusCode)
+	}
+}
+
+func TestWebSocketMissingScope(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+
+	_, secret, err := server.tokens.Create("reader", []string{auth.ScopeRead}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+server.URL[4:]+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect WebSocket: %v", err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "test complete")
+
+	if resp := call(ctx, t, conn, 1, "auth", map[string]string{"token": secret}); resp["error"] != nil {
+		t.Fatalf("Auth failed: %v", resp["error"])
+	}
+	if resp := call(ctx, t, conn, 2, "session.list", map[string]interface{}{}); resp["error"] != nil {
+		t.Errorf("Expected a read to succeed, got %v", resp["error"])
+	}
+	resp := call(ctx, t, conn, 3, "session.create", map[string]string{"title": "Denied"})
+	rpcErr, _ := resp["error"].(map[string]interface{})
+	if rpcErr["code"] != float64(-32004) {
+		t.Errorf("Expected error -32004 for a chat method, got %v", resp["error"])
+	}
+}
+
+func TestLockout(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+
+	// The first failures only get 401; the fifth locks the client out
+	for i := 1; i <= 5; i++ {
+		if resp := get(t, server.URL+"/api/fs/", "wrong-token"); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d: expected status 401, got %d", i, resp.StatusCode)
+		}
+	}
+
+	// Even a valid secret is refused while locked out
+	resp := get(t, server.URL+"/api/fs/", testToken)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+
+	for i := 0; i < 5; i++ {
+		get(t, server.URL+"/api/fs/", "wrong-token")
+	}
+
+	f, err := os.Open(server.cfg.AuditLogPath())
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var events []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid audit log line %q: %v", scanner.Text(), err)
+		}
+		if entry["action"] != "GET /api/fs/" || entry["ip"] == "" {
+			t.Errorf("Unexpected audit entry: %v", entry)
+		}
+		events = append(events, entry["event"].(string))
+	}
+
+	want := []string{"auth_failed", "auth_failed", "auth_failed", "auth_failed", "locked_out"}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected events %v, got %v", want, events)
+	}
+}
+
+// issueTicket requests a ticket with the test token
+func issueTicket(t *testing.T, server *testServer) string {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/auth/ticket", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Ticket request failed: %v", err)
+	}
+	var ticket struct {
+		Ticket string `json:"ticket"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&ticket)
+	resp.Body.Close()
+	if err != nil || ticket.Ticket == "" {
+		t.Fatalf("Failed to decode ticket (status %d): %v", resp.StatusCode, err)
+	}
+	return ticket.Ticket
+}
+
+func TestWebSocketTicketLogin(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+
+	wsURL := "ws" + server.URL[4:] + "/ws?ticket=" + issueTicket(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect WebSocket: %v", err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "test complete")
+
+	// The ticket authenticated the connection; auth only negotiates
+	authReq := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "auth",
+		"params":  map[string]interface{}{},
+		"id":      1,
+	}
+	if err := wsjson.Write(ctx, conn, authReq); err != nil {
+		t.Fatalf("Failed to send auth request: %v", err)
+	}
+	var authResp map[string]interface{}
+	if err := wsjson.Read(ctx, conn, &authResp); err != nil {
+		t.Fatalf("Failed to read auth response: %v", err)
+	}
+	if authResp["error"] != nil {
+		t.Fatalf("Auth failed: %v", authResp["error"])
+	}
+
+	// Tickets are single-use
+	_, resp, err := websocket.Dial(ctx, wsURL, nil)
+	if err == nil {
+		t.Fatal("Expected a reused ticket to be rejected")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a reused ticket, got %v", resp)
+	}
+}
+
+func TestTicketRoutes(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+	sess := server.handler.GetSessionStore().Create("Tickets", "")
+
+	// Elsewhere a ticket is ignored and stays valid
+	ticket := issueTicket(t, server)
+	for _, path := range []string{"/api/sessions/" + sess.ID + "/messages", "/api/git/status"} {
+		if resp := get(t, server.URL+path+"?ticket="+ticket, ""); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: expected status 401 with a ticket, got %d", path, resp.StatusCode)
+		}
+	}
+	if resp := get(t, server.URL+"/api/sessions/"+sess.ID+"/export?ticket="+ticket, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the ticket to open the export, got %d", resp.StatusCode)
+	}
+	if resp := get(t, server.URL+"/api/fs/?ticket="+issueTicket(t, server), ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected a ticket to open a download, got %d", resp.StatusCode)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/Noon-R/Devport/server/api"
+	"github.com/Noon-R/Devport/server/auth"
 	"github.com/Noon-R/Devport/server/config"
 	"github.com/Noon-R/Devport/server/ws"
 	"github.com/coder/websocket"
@@ -17,7 +18,15 @@ import (
 
 const testToken = "test-token"
 
-func setupTestServer(t *testing.T) *httptest.Server {
+// testServer is a server wired like main.go, behind the auth guard
+type testServer struct {
+	*httptest.Server
+	cfg     *config.Config
+	tokens  *auth.Store
+	handler *ws.Handler
+}
+
+func setupTestServer(t *testing.T) *testServer {
 	cfg := &config.Config{
 		AuthToken:    testToken,
 		ServerPort:   "0",
@@ -27,6 +36,17 @@ func setupTestServer(t *testing.T) *httptest.Server {
 		RelayEnabled: false,
 	}
 
+	tokens, err := auth.NewStore(cfg.TokensPath(), cfg.AuthToken)
+	if err != nil {
+		t.Fatalf("Failed to create token store: %v", err)
+	}
+	guard, err := auth.NewGuard(tokens, cfg.AuditLogPath(), false)
+	if err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+	t.Cleanup(func() { guard.Close() })
+	guard.SetTicketRoutes(api.TicketRoute)
+
 	mux := http.NewServeMux()
 
 	// Health check
@@ -37,20 +57,33 @@ func setupTestServer(t *testing.T) *httptest.Server {
 
 	// WebSocket
 	wsHandler := ws.NewHandler(cfg)
+	wsHandler.SetTokenStore(tokens)
 	mux.Handle("/ws", wsHandler)
 
 	// APIs
-	fsHandler := api.NewFSHandler(cfg.WorkDir, cfg.AuthToken)
+	fsHandler := api.NewFSHandler(cfg.WorkDir, wsHandler.GetSessionStore())
+	fsHandler.SetRoots(wsHandler.GetRoots())
 	mux.Handle("/api/fs/", fsHandler)
 	mux.Handle("/api/fs", fsHandler)
 
-	gitHandler := api.NewGitHandler(cfg.WorkDir, cfg.AuthToken)
+	gitHandler := api.NewGitHandler(cfg.WorkDir, wsHandler.GetSessionStore())
+	gitHandler.SetRoots(wsHandler.GetRoots())
 	mux.Handle("/api/git/", gitHandler)
 
-	chatHandler := api.NewChatHandler(cfg.AuthToken, wsHandler.GetSessionStore(), wsHandler.GetProcessManager())
+	chatHandler := api.NewChatHandler(wsHandler.GetSessionStore(), wsHandler.GetProcessManager())
+	chatHandler.SetTurnRunner(wsHandler.GetTurnRunner())
+	chatHandler.SetStreams(wsHandler.GetStreamHub())
 	mux.Handle("/api/sessions/", chatHandler)
 
-	return httptest.NewServer(mux)
+	mux.Handle("/api/pair", api.NewPairHandler(tokens))
+	mux.Handle("/api/auth/", api.NewAuthHandler(tokens))
+
+	return &testServer{
+		Server:  httptest.NewServer(guard.Middleware(mux)),
+		cfg:     cfg,
+		tokens:  tokens,
+		handler: wsHandler,
+	}
 }
 
 func TestHealthCheck(t *testing.T) {
@@ -304,3 +337,66 @@ func TestSessionList(t *testing.T) {
 		t.Errorf("Expected 0 sessions, got %d", len(sessions))
 	}
 }
+
+// call sends a JSON-RPC request and returns its response
+func call(ctx context.Context, t *testing.T, conn *websocket.Conn, id int, method string, params interface{}) map[string]interface{} {
+	t.Helper()
+	req := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+		"id":      id,
+	}
+	if err := wsjson.Write(ctx, conn, req); err != nil {
+		t.Fatalf("Failed to send %s request: %v", method, err)
+	}
+	for {
+		var resp map[string]interface{}
+		if err := wsjson.Read(ctx, conn, &resp); err != nil {
+			t.Fatalf("Failed to read %s response: %v", method, err)
+		}
+		// Skip notifications
+		if resp["id"] != nil {
+			return resp
+		}
+	}
+}
+
+func TestStatsCountEvents(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+
+	wsURL := "ws" + server.URL[4:] + "/ws"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect WebSocket: %v", err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "test complete")
+
+	if resp := call(ctx, t, conn, 1, "auth", map[string]string{"token": testToken}); resp["error"] != nil {
+		t.Fatalf("Auth failed: %v", resp["error"])
+	}
+	if resp := call(ctx, t, conn, 2, "session.create", map[string]string{"title": "Counted"}); resp["error"] != nil {
+		t.Fatalf("Session create failed: %v", resp["error"])
+	}
+
+	// Events reach the metrics subscriber asynchronously
+	for id := 3; ; id++ {
+		resp := call(ctx, t, conn, id, "rpc.stats", map[string]interface{}{})
+		if resp["error"] != nil {
+			t.Fatalf("rpc.stats failed: %v", resp["error"])
+		}
+		counts, _ := resp["result"].(map[string]interface{})["events"].(map[string]interface{})
+		if counts["session.created"] == float64(1) {
+			return
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("Expected 1 session.created event, got %v", counts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,90 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Noon-R/Devport/server/msgpack"
+	"github.com/coder/websocket"
+)
+
+// readBinary reads frames until the response with id, requiring every frame
+// to be MessagePack
+func readBinary(ctx context.Context, t *testing.T, conn *websocket.Conn, id float64) map[string]interface{} {
+	t.Helper()
+	for {
+		msgType, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("Failed to read response %v: %v", id, err)
+		}
+		if msgType != websocket.MessageBinary {
+			t.Fatalf("Expected a binary frame, got %s", data)
+		}
+		jsonData, err := msgpack.ToJSON(data)
+		if err != nil {
+			t.Fatalf("Invalid MessagePack frame: %v", err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(jsonData, &msg); err != nil {
+			t.Fatalf("Invalid message %s: %v", jsonData, err)
+		}
+		if msg["id"] == id || (id == 0 && msg["error"] != nil) {
+			return msg
+		}
+	}
+}
+
+func TestMessagePackFrames(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+
+	wsURL := "ws" + server.URL[4:] + "/ws"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect WebSocket: %v", err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "test complete")
+
+	// auth is sent as JSON and answered in JSON
+	resp := call(ctx, t, conn, 1, "auth", map[string]string{"token": testToken, "encoding": "msgpack"})
+	if resp["error"] != nil {
+		t.Fatalf("Auth failed: %v", resp["error"])
+	}
+
+	req, err := msgpack.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "session.create",
+		"params":  map[string]string{"title": "Packed"},
+		"id":      2,
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if err := conn.Write(ctx, websocket.MessageBinary, req); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp = readBinary(ctx, t, conn, 2)
+	if resp["error"] != nil {
+		t.Fatalf("Session create failed: %v", resp["error"])
+	}
+	result, _ := resp["result"].(map[string]interface{})
+	session, _ := result["session"].(map[string]interface{})
+	if session["title"] != "Packed" {
+		t.Errorf("Expected title 'Packed', got %v", session["title"])
+	}
+
+	// Malformed MessagePack gets a parse error in MessagePack
+	if err := conn.Write(ctx, websocket.MessageBinary, []byte{0x81, 0xa4, 'n'}); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp = readBinary(ctx, t, conn, 0)
+	if code := resp["error"].(map[string]interface{})["code"]; code != float64(-32700) {
+		t.Errorf("Expected error -32700, got %v", code)
+	}
+}
@@ -0,0 +1,39 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceForgetsStreams(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+
+	store := server.handler.GetSessionStore()
+	streams := server.handler.GetStreamHub()
+
+	deleted := store.Create("Old", "")
+	unloaded := store.Create("Idle", "")
+	store.GetHistory(unloaded.ID) // load the history so there is something to unload
+	deleted.UpdatedAt = time.Now().Add(-72 * time.Hour)
+	unloaded.UpdatedAt = time.Now().Add(-36 * time.Hour)
+	for _, id := range []string{deleted.ID, unloaded.ID} {
+		streams.Publish(id, "chat.text", map[string]interface{}{"content": "x"})
+	}
+
+	policy := server.handler.RetentionPolicy()
+	policy.DeleteAfter = 48 * time.Hour
+	report := store.Maintain(policy)
+	if len(report.Deleted) != 1 || report.Unloaded != 1 {
+		t.Fatalf("Expected 1 deleted and 1 unloaded session, got %+v", report)
+	}
+
+	// The bus delivers the events asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for streams.LastSeq(deleted.ID) != 0 || streams.LastSeq(unloaded.ID) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Stream buffers of deleted and unloaded sessions were kept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
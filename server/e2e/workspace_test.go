@@ -0,0 +1,184 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// request sends an authenticated request with the test token
+func request(t *testing.T, method, url, body string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, data
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSRejectsStateDir(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+
+	workDir := server.cfg.WorkDir
+	writeFile(t, server.cfg.TokensPath(), "secret")
+	writeFile(t, filepath.Join(workDir, "app", ".devport", "meta.json"), "{}")
+	writeFile(t, filepath.Join(workDir, "app", "main.go"), "package main")
+	if err := os.Symlink(filepath.Join(workDir, ".devport"), filepath.Join(workDir, "state")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, method, path string
+		want               int
+	}{
+		{"read tokens", http.MethodGet, "/api/fs/.devport/tokens.json", http.StatusForbidden},
+		{"list state", http.MethodGet, "/api/fs/.devport", http.StatusForbidden},
+		{"overwrite tokens", http.MethodPut, "/api/fs/.devport/tokens.json", http.StatusForbidden},
+		{"create in state", http.MethodPut, "/api/fs/.devport/salt", http.StatusForbidden},
+		{"delete state", http.MethodDelete, "/api/fs/.devport", http.StatusForbidden},
+		{"nested state", http.MethodGet, "/api/fs/app/.devport/meta.json", http.StatusForbidden},
+		{"symlink to state", http.MethodGet, "/api/fs/state/tokens.json", http.StatusForbidden},
+		{"create through symlink", http.MethodPut, "/api/fs/state/new/file", http.StatusForbidden},
+		{"project file", http.MethodGet, "/api/fs/app/main.go", http.StatusOK},
+		{"project write", http.MethodPut, "/api/fs/app/notes.txt", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := request(t, tt.method, server.URL+tt.path, "overwritten")
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, resp.StatusCode, body)
+			}
+		})
+	}
+
+	if data, _ := os.ReadFile(server.cfg.TokensPath()); string(data) != "secret" {
+		t.Errorf("Expected the token file unchanged, got %q", data)
+	}
+	for _, path := range []string{".devport/salt", ".devport/new"} {
+		if _, err := os.Stat(filepath.Join(workDir, path)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to be created", path)
+		}
+	}
+}
+
+func TestGitHidesStateDir(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	server := setupTestServer(t)
+	defer server.Close()
+
+	workDir := server.cfg.WorkDir
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = workDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	writeFile(t, filepath.Join(workDir, "main.go"), "package main\n")
+	writeFile(t, server.cfg.TokensPath(), "old\n")
+	git("add", "-A")
+	git("commit", "-qm", "initial")
+
+	writeFile(t, filepath.Join(workDir, "main.go"), "package main\n\nfunc main() {}\n")
+	writeFile(t, server.cfg.TokensPath(), "new secret\n")
+	writeFile(t, filepath.Join(workDir, ".devport", "encryption-salt"), "salt")
+	writeFile(t, filepath.Join(workDir, "app", ".devport", "meta.json"), "{}")
+
+	for _, path := range []string{"/api/git/status", "/api/git/diff"} {
+		resp, body := request(t, http.MethodGet, server.URL+path, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, resp.StatusCode)
+		}
+		if strings.Contains(string(body), ".devport") || strings.Contains(string(body), "secret") {
+			t.Errorf("%s exposed server state: %s", path, body)
+		}
+		if !strings.Contains(string(body), "main.go") {
+			t.Errorf("%s is missing the project change: %s", path, body)
+		}
+	}
+}
+
+func TestSessionWorkDirChecked(t *testing.T) {
+	server := setupTestServer(t)
+	defer server.Close()
+
+	store := server.handler.GetSessionStore()
+	inside := filepath.Join(server.cfg.WorkDir, "app")
+	if err := os.MkdirAll(inside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(server.cfg.WorkDir, ".devport"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Work dirs as they could be found in edited session files
+	tests := []struct {
+		name    string
+		workDir string
+		want    int
+	}{
+		{"inside the roots", inside, http.StatusOK},
+		{"outside the roots", t.TempDir(), http.StatusForbidden},
+		{"state dir", filepath.Join(server.cfg.WorkDir, ".devport"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := store.Create(tt.name, tt.workDir)
+			for _, path := range []string{"/api/fs/", "/api/git/status"} {
+				resp, body := request(t, http.MethodGet, server.URL+path+"?session_id="+sess.ID, "")
+				if resp.StatusCode != tt.want {
+					t.Errorf("%s: expected %d, got %d: %s", path, tt.want, resp.StatusCode, body)
+				}
+			}
+		})
+	}
+
+	// The agent does not start in a work dir outside the roots
+	outside := store.Create("Outside", t.TempDir())
+	processes := server.handler.GetProcessManager()
+	if _, err := processes.GetOrCreate(context.Background(), outside.ID); err == nil {
+		processes.Release(outside.ID)
+		t.Error("Expected the agent not to start outside the roots")
+	}
+	started := store.Create("Inside", inside)
+	if _, err := processes.GetOrCreate(context.Background(), started.ID); err != nil {
+		t.Errorf("Expected the agent to start inside the roots, got %v", err)
+	}
+	processes.Close(started.ID)
+
+	resp, body := request(t, http.MethodGet, server.URL+"/api/fs/?session_id=missing", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown session, got %d: %s", resp.StatusCode, body)
+	}
+	var listing map[string]interface{}
+	if resp, body := request(t, http.MethodGet, server.URL+"/api/fs/", ""); resp.StatusCode != http.StatusOK || json.Unmarshal(body, &listing) != nil {
+		t.Errorf("Expected the default work dir to be listed, got %d: %s", resp.StatusCode, body)
+	}
+}
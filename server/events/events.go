@@ -0,0 +1,197 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Type identifies the kind of an event
+type Type string
+
+// Event types
+const (
+	SessionCreated      Type = "session.created"
+	SessionUpdated      Type = "session.updated"
+	SessionDeleted      Type = "session.deleted"
+	SessionUnloaded     Type = "session.unloaded" // history dropped from memory
+	TurnStarted         Type = "turn.started"
+	TurnFinished        Type = "turn.finished"
+	PermissionRequested Type = "permission.requested"
+	ProcessEnded        Type = "process.ended"
+	FileChanged         Type = "file.changed"
+)
+
+// historySize is the number of recent events kept for polling clients
+const historySize = 256
+
+// queueSize is the number of undelivered events a subscriber may fall behind
+const queueSize = 256
+
+// Event is something that happened in the server
+type Event struct {
+	ID        int64       `json:"id"`
+	Type      Type        `json:"type"`
+	SessionID string      `json:"session_id,omitempty"`
+	Time      time.Time   `json:"time"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// Handler receives events
+type Handler func(Event)
+
+// Bus distributes server events to subscribers. Each subscriber is fed from
+// its own queue, so a slow subscriber never blocks publishers or the other
+// subscribers; it loses events instead once its queue is full.
+//
+// All methods are safe to call on a nil *Bus, which drops every event.
+type Bus struct {
+	mu      sync.Mutex
+	nextID  int64
+	subs    map[*subscription]struct{}
+	history []Event
+	waiters chan struct{} // closed and replaced on every publish
+}
+
+type subscription struct {
+	types map[Type]bool // nil for all types
+	queue chan Event
+	done  chan struct{}
+}
+
+// NewBus creates an event bus
+func NewBus() *Bus {
+	return &Bus{
+		subs:    make(map[*subscription]struct{}),
+		waiters: make(chan struct{}),
+	}
+}
+
+// Publish stamps an event with its ID and time and queues it for every
+// subscriber interested in its type
+func (b *Bus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event.ID = b.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.history = append(b.history, event)
+	if len(b.history) > historySize {
+		b.history = b.history[len(b.history)-historySize:]
+	}
+	close(b.waiters)
+	b.waiters = make(chan struct{})
+
+	for sub := range b.subs {
+		if sub.types != nil && !sub.types[event.Type] {
+			continue
+		}
+		select {
+		case sub.queue <- event:
+		default:
+			log.Printf("Event bus: subscriber queue full, dropping %s", event.Type)
+		}
+	}
+}
+
+// Subscribe calls fn for every event of the given types, or of all types if
+// none are given, until the returned function is called. Events are
+// delivered in order on a goroutine owned by the subscription.
+func (b *Bus) Subscribe(fn Handler, types ...Type) (unsubscribe func()) {
+	if b == nil {
+		return func() {}
+	}
+
+	sub := &subscription{
+		queue: make(chan Event, queueSize),
+		done:  make(chan struct{}),
+	}
+	if len(types) > 0 {
+		sub.types = make(map[Type]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case event := <-sub.queue:
+				fn(event)
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, sub)
+			b.mu.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+// Since returns the recent events with an ID greater than after, waiting
+// until one is published or ctx is done if there are none yet. The second
+// result is false if older events after it have already been discarded.
+func (b *Bus) Since(ctx context.Context, after int64) ([]Event, bool) {
+	if b == nil {
+		<-ctx.Done()
+		return nil, true
+	}
+
+	for {
+		b.mu.Lock()
+		if after > b.nextID {
+			// Not an ID of this bus, e.g. from before a restart
+			b.mu.Unlock()
+			return nil, false
+		}
+		var events []Event
+		for _, event := range b.history {
+			if event.ID > after {
+				events = append(events, event)
+			}
+		}
+		complete := len(b.history) == 0 || b.history[0].ID <= after+1
+		wait := b.waiters
+		b.mu.Unlock()
+
+		if len(events) > 0 {
+			return events, complete
+		}
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, true
+		}
+	}
+}
+
+// LastID returns the ID of the most recent event
+func (b *Bus) LastID() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextID
+}
@@ -11,15 +11,25 @@ import (
 	"time"
 
 	"github.com/Noon-R/Devport/server/api"
+	"github.com/Noon-R/Devport/server/auth"
 	"github.com/Noon-R/Devport/server/config"
+	"github.com/Noon-R/Devport/server/crypt"
+	"github.com/Noon-R/Devport/server/process"
 	"github.com/Noon-R/Devport/server/qr"
 	"github.com/Noon-R/Devport/server/relay"
+	"github.com/Noon-R/Devport/server/session"
+	"github.com/Noon-R/Devport/server/webhook"
 	"github.com/Noon-R/Devport/server/ws"
 )
 
 func main() {
 	cfg := config.Load()
 
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		rotateKey(cfg)
+		return
+	}
+
 	if cfg.AuthToken == "" {
 		log.Fatal("AUTH_TOKEN environment variable is required")
 	}
@@ -32,25 +42,67 @@ func main() {
 		w.Write([]byte(`{"status":"ok"}`))
 	})
 
+	// Session storage, encrypted at rest when a key is configured
+	key, err := crypt.LoadKey(cfg.EncryptionKeyFile, cfg.EncryptionPassphrase, cfg.EncryptionSaltPath())
+	if err != nil {
+		log.Fatalf("Failed to load encryption key: %v", err)
+	}
+	sessionStore := session.NewStoreWithKey(cfg.WorkDir, key)
+	processManager := process.NewManager(cfg.WorkDir, 10*time.Minute)
+
+	// Named API tokens; AUTH_TOKEN keeps every scope
+	tokens, err := auth.NewStore(cfg.TokensPath(), cfg.AuthToken)
+	if err != nil {
+		log.Fatalf("Failed to load tokens: %v", err)
+	}
+
+	// Every request is authenticated by the guard, which locks out clients
+	// after repeated failures and keeps an audit log
+	guard, err := auth.NewGuard(tokens, cfg.AuditLogPath(), cfg.TrustProxy)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer guard.Close()
+	guard.SetTicketRoutes(api.TicketRoute)
+
 	// WebSocket endpoint
-	wsHandler := ws.NewHandler(cfg)
+	wsHandler := ws.NewHandlerWithDeps(cfg, sessionStore, processManager)
+	wsHandler.SetTokenStore(tokens)
 	mux.Handle("/ws", wsHandler)
 
+	// Server events: long polling and webhooks
+	bus := wsHandler.GetEventBus()
+	mux.Handle("/api/events", api.NewEventsHandler(bus))
+	if len(cfg.WebhookURLs) > 0 {
+		webhook.New(cfg.WebhookURLs, cfg.WebhookSecret).Attach(bus)
+	}
+
 	// File system API
-	fsHandler := api.NewFSHandler(cfg.WorkDir, cfg.AuthToken)
+	fsHandler := api.NewFSHandler(cfg.WorkDir, wsHandler.GetSessionStore())
+	fsHandler.SetEventBus(bus)
+	fsHandler.SetRoots(wsHandler.GetRoots())
 	mux.Handle("/api/fs/", fsHandler)
 	mux.Handle("/api/fs", fsHandler)
 
 	// Git API
-	gitHandler := api.NewGitHandler(cfg.WorkDir, cfg.AuthToken)
+	gitHandler := api.NewGitHandler(cfg.WorkDir, wsHandler.GetSessionStore())
+	gitHandler.SetRoots(wsHandler.GetRoots())
 	mux.Handle("/api/git/", gitHandler)
 
 	// Chat REST API (for reliable message delivery)
-	chatHandler := api.NewChatHandler(cfg.AuthToken, wsHandler.GetSessionStore(), wsHandler.GetProcessManager())
+	chatHandler := api.NewChatHandler(wsHandler.GetSessionStore(), wsHandler.GetProcessManager())
+	chatHandler.SetTurnRunner(wsHandler.GetTurnRunner())
+	chatHandler.SetStreams(wsHandler.GetStreamHub())
 	mux.Handle("/api/sessions/", chatHandler)
 	mux.Handle("/api/permissions/", chatHandler)
 	mux.Handle("/api/questions/", chatHandler)
 
+	// Device pairing with the code from the startup QR
+	mux.Handle("/api/pair", api.NewPairHandler(tokens))
+
+	// Access tokens, refresh tokens and URL tickets
+	mux.Handle("/api/auth/", api.NewAuthHandler(tokens))
+
 	// Static files (production mode)
 	if !cfg.DevMode {
 		mux.Handle("/", http.FileServer(http.Dir("./static")))
@@ -60,6 +112,9 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Apply session retention policy in the background
+	go wsHandler.GetSessionStore().RunMaintenance(ctx, wsHandler.RetentionPolicy(), cfg.MaintenanceInterval)
+
 	var relayClient *relay.Client
 	var remoteURL string
 
@@ -78,13 +133,17 @@ func main() {
 	addr := ":" + cfg.ServerPort
 	localURL := fmt.Sprintf("http://localhost%s", addr)
 
-	// Print startup banner with QR code
-	qr.PrintStartupBanner(localURL, remoteURL)
+	// Print startup banner with a pairing code in the QR code
+	pairingCode, _, err := tokens.NewPairingCode(cfg.PairingCodeTTL)
+	if err != nil {
+		log.Printf("Warning: Failed to create pairing code: %v", err)
+	}
+	qr.PrintStartupBanner(localURL, remoteURL, pairingCode)
 
 	// Setup graceful shutdown
 	server := &http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: guard.Middleware(mux),
 	}
 
 	go func() {
@@ -110,3 +169,36 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// rotateKey re-encrypts stored sessions with a new key. The current key comes
+// from ENCRYPTION_KEY_FILE / ENCRYPTION_PASSPHRASE (unset for a plaintext
+// store) and the new one from NEW_ENCRYPTION_KEY_FILE /
+// NEW_ENCRYPTION_PASSPHRASE. Stop the server before running it.
+func rotateKey(cfg *config.Config) {
+	oldKey, err := crypt.LoadKey(cfg.EncryptionKeyFile, cfg.EncryptionPassphrase, cfg.EncryptionSaltPath())
+	if err != nil {
+		log.Fatalf("Failed to load current key: %v", err)
+	}
+
+	// A new passphrase gets a new salt, kept aside until the rotation
+	// succeeded so that an interrupted run can be repeated
+	newSalt := cfg.EncryptionSaltPath() + ".new"
+	newKey, err := crypt.LoadKey(os.Getenv("NEW_ENCRYPTION_KEY_FILE"), os.Getenv("NEW_ENCRYPTION_PASSPHRASE"), newSalt)
+	if err != nil {
+		log.Fatalf("Failed to load new key: %v", err)
+	}
+	if newKey == nil {
+		log.Fatal("NEW_ENCRYPTION_KEY_FILE or NEW_ENCRYPTION_PASSPHRASE is required")
+	}
+
+	n, err := session.RotateKey(cfg.WorkDir, oldKey, newKey)
+	if err != nil {
+		log.Fatalf("Key rotation failed after %d files: %v", n, err)
+	}
+	if _, err := os.Stat(newSalt); err == nil {
+		if err := os.Rename(newSalt, cfg.EncryptionSaltPath()); err != nil {
+			log.Fatalf("Failed to store new salt: %v", err)
+		}
+	}
+	log.Printf("Re-encrypted %d session files with key %s", n, newKey.ID())
+}
@@ -0,0 +1,319 @@
+// Package msgpack converts between JSON and MessagePack, the compact binary
+// encoding clients may select for the WebSocket protocol. Values keep the
+// shape of their JSON encoding, so struct tags apply as usual.
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// maxDepth limits nesting when decoding untrusted input
+const maxDepth = 100
+
+var (
+	ErrTruncated   = errors.New("msgpack: unexpected end of data")
+	ErrUnsupported = errors.New("msgpack: unsupported type")
+	ErrTooDeep     = errors.New("msgpack: nesting too deep")
+)
+
+// Marshal encodes v as MessagePack via its JSON encoding
+func Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return FromJSON(data)
+}
+
+// FromJSON converts a JSON document to MessagePack
+func FromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ToJSON converts a MessagePack document to JSON. Map keys must be strings;
+// binary values become base64 strings.
+func ToJSON(data []byte) ([]byte, error) {
+	d := decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("msgpack: %d trailing bytes", len(d.data)-d.pos)
+	}
+	return json.Marshal(v)
+}
+
+func encode(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			encodeInt(buf, i)
+		} else if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			buf.WriteByte(0xcf)
+			buf.Write(binary.BigEndian.AppendUint64(nil, u))
+		} else {
+			f, err := v.Float64()
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(0xcb)
+			buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+		}
+	case string:
+		encodeString(buf, v)
+	case []interface{}:
+		encodeLength(buf, len(v), 0x90, 15, 0xdc)
+		for _, item := range v {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		encodeLength(buf, len(v), 0x80, 15, 0xde)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			encodeString(buf, k)
+			if err := encode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupported, v)
+	}
+	return nil
+}
+
+func encodeInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.Write([]byte{0xd0, byte(int8(i))})
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(int16(i))))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(int32(i))))
+	default:
+		buf.WriteByte(0xd3)
+		buf.Write(binary.BigEndian.AppendUint64(nil, uint64(i)))
+	}
+}
+
+func encodeString(buf *bytes.Buffer, s string) {
+	if len(s) <= 31 {
+		buf.WriteByte(0xa0 | byte(len(s)))
+	} else if len(s) <= math.MaxUint8 {
+		buf.Write([]byte{0xd9, byte(len(s))})
+	} else {
+		encodeLength(buf, len(s), 0, -1, 0xda)
+	}
+	buf.WriteString(s)
+}
+
+// encodeLength writes the header of an array, map or long string: a fix
+// type for lengths up to fixMax, otherwise the 16-bit code or the 32-bit
+// code that follows it
+func encodeLength(buf *bytes.Buffer, n int, fix byte, fixMax int, code16 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(code16 + 1)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, ErrTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uint(size int) (uint64, error) {
+	b, err := d.take(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+func (d *decoder) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, ErrTooDeep
+	}
+	b, err := d.take(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return d.arrayOf(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return d.str(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 0xca:
+		u, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.uint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uint(1 << (c - 0xcc))
+	case 0xd0:
+		u, err := d.uint(1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err := d.uint(2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err := d.uint(4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err := d.uint(8)
+		return int64(u), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(int(n), depth)
+	}
+	return nil, fmt.Errorf("%w: 0x%02x", ErrUnsupported, c)
+}
+
+func (d *decoder) str(n int) (string, error) {
+	b, err := d.take(n)
+	return string(b), err
+}
+
+func (d *decoder) arrayOf(n int, depth int) ([]interface{}, error) {
+	// Every element takes at least one byte
+	if n > len(d.data)-d.pos {
+		return nil, ErrTruncated
+	}
+	items := make([]interface{}, n)
+	for i := range items {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = v
+	}
+	return items, nil
+}
+
+func (d *decoder) mapOf(n int, depth int) (map[string]interface{}, error) {
+	if n > (len(d.data)-d.pos)/2 {
+		return nil, ErrTruncated
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: map key %T", ErrUnsupported, k)
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
@@ -0,0 +1,212 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodeJSON decodes data keeping numbers exact
+func decodeJSON(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", data, err)
+	}
+	return v
+}
+
+func jsonArray(n int) string {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = fmt.Sprint(i)
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+func jsonObject(n int) string {
+	fields := make([]string, n)
+	for i := range fields {
+		fields[i] = fmt.Sprintf(`"k%d":%d`, i, i)
+	}
+	return "{" + strings.Join(fields, ",") + "}"
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"null", `null`},
+		{"true", `true`},
+		{"false", `false`},
+		{"float", `1.5`},
+		{"negative float", `-0.25`},
+		{"empty string", `""`},
+		{"fixstr", `"` + strings.Repeat("a", 31) + `"`},
+		{"str8", `"` + strings.Repeat("a", 32) + `"`},
+		{"str16", `"` + strings.Repeat("a", 256) + `"`},
+		{"str32", `"` + strings.Repeat("a", 1<<16) + `"`},
+		{"unicode", `"日本語 ✓"`},
+		{"empty array", `[]`},
+		{"fixarray", jsonArray(15)},
+		{"array16", jsonArray(16)},
+		{"array32", jsonArray(1 << 16)},
+		{"empty map", `{}`},
+		{"fixmap", jsonObject(15)},
+		{"map16", jsonObject(16)},
+		{"map32", jsonObject(1 << 16)},
+		{"nested", `{"jsonrpc":"2.0","id":7,"params":{"list":[1,[2,[3,{"deep":null}]],{"a":true}],"empty":{}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packed, err := FromJSON([]byte(tt.json))
+			if err != nil {
+				t.Fatalf("FromJSON failed: %v", err)
+			}
+			back, err := ToJSON(packed)
+			if err != nil {
+				t.Fatalf("ToJSON failed: %v", err)
+			}
+			if want, got := decodeJSON(t, []byte(tt.json)), decodeJSON(t, back); !reflect.DeepEqual(want, got) {
+				t.Errorf("Round trip changed the value: got %.100s", back)
+			}
+		})
+	}
+}
+
+func TestIntegerWidths(t *testing.T) {
+	tests := []struct {
+		value  string
+		header byte
+		size   int
+	}{
+		{"0", 0x00, 1},
+		{"127", 0x7f, 1},
+		{"-1", 0xff, 1},
+		{"-32", 0xe0, 1},
+		{"-33", 0xd0, 2},
+		{"-128", 0xd0, 2},
+		{"128", 0xd1, 3},
+		{"-129", 0xd1, 3},
+		{"32767", 0xd1, 3},
+		{"-32768", 0xd1, 3},
+		{"32768", 0xd2, 5},
+		{"-32769", 0xd2, 5},
+		{"2147483647", 0xd2, 5},
+		{"-2147483648", 0xd2, 5},
+		{"2147483648", 0xd3, 9},
+		{"-2147483649", 0xd3, 9},
+		{"9223372036854775807", 0xd3, 9},
+		{"-9223372036854775808", 0xd3, 9},
+		{"9223372036854775808", 0xcf, 9},
+		{"18446744073709551615", 0xcf, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			packed, err := FromJSON([]byte(tt.value))
+			if err != nil {
+				t.Fatalf("FromJSON failed: %v", err)
+			}
+			if packed[0] != tt.header || len(packed) != tt.size {
+				t.Errorf("Expected header 0x%02x and %d bytes, got 0x%02x and %d bytes", tt.header, tt.size, packed[0], len(packed))
+			}
+			back, err := ToJSON(packed)
+			if err != nil {
+				t.Fatalf("ToJSON failed: %v", err)
+			}
+			if string(back) != tt.value {
+				t.Errorf("Expected %s, got %s", tt.value, back)
+			}
+		})
+	}
+}
+
+// Types clients may send that FromJSON never produces
+func TestToJSONForeignTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"uint8", []byte{0xcc, 0xff}, `255`},
+		{"uint16", []byte{0xcd, 0xff, 0xff}, `65535`},
+		{"uint32", []byte{0xce, 0xff, 0xff, 0xff, 0xff}, `4294967295`},
+		{"float32", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, `1.5`},
+		{"bin8", []byte{0xc4, 0x03, 'a', 'b', 'c'}, `"YWJj"`},
+		{"bin16", []byte{0xc5, 0x00, 0x01, 'a'}, `"YQ=="`},
+		{"str8 key", []byte{0x81, 0xd9, 0x01, 'k', 0x01}, `{"k":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToJSON(tt.data)
+			if err != nil {
+				t.Fatalf("ToJSON failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestToJSONTruncated(t *testing.T) {
+	packed, err := FromJSON([]byte(`{"method":"chat.send","params":{"content":"` + strings.Repeat("x", 300) + `","ids":[1,300,70000,5000000000]},"ok":true,"f":0.5}`))
+	if err != nil {
+		t.Fatalf("FromJSON failed: %v", err)
+	}
+	for n := 0; n < len(packed); n++ {
+		if _, err := ToJSON(packed[:n]); !errors.Is(err, ErrTruncated) {
+			t.Fatalf("Expected ErrTruncated for %d of %d bytes, got %v", n, len(packed), err)
+		}
+	}
+}
+
+func TestToJSONInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"reserved type", []byte{0xc1}, ErrUnsupported},
+		{"extension", []byte{0xd4, 0x01, 0x02}, ErrUnsupported},
+		{"integer map key", []byte{0x81, 0x01, 0x02}, ErrUnsupported},
+		{"too deep", append(bytes.Repeat([]byte{0x91}, maxDepth+1), 0xc0), ErrTooDeep},
+		{"array longer than input", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, ErrTruncated},
+		{"map longer than input", []byte{0xdf, 0xff, 0xff, 0xff, 0xff, 0xc0}, ErrTruncated},
+		{"string longer than input", []byte{0xdb, 0xff, 0xff, 0xff, 0xff, 'a'}, ErrTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ToJSON(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	if _, err := ToJSON([]byte{0xc0, 0xc0}); err == nil {
+		t.Error("Expected an error for trailing bytes")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	type payload struct {
+		Name  string `json:"name"`
+		Count int    `json:"count,omitempty"`
+	}
+	packed, err := Marshal(payload{Name: "a"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	// A one-entry map: the struct tags apply and empty fields are omitted
+	want := []byte{0x81, 0xa4, 'n', 'a', 'm', 'e', 0xa1, 'a'}
+	if !bytes.Equal(packed, want) {
+		t.Errorf("Expected % x, got % x", want, packed)
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/Noon-R/Devport/server/agent"
 	"github.com/Noon-R/Devport/server/agent/claude"
+	"github.com/Noon-R/Devport/server/events"
 )
 
 // Manager manages Claude CLI processes for sessions
@@ -15,6 +16,16 @@ type Manager struct {
 	processes   sync.Map // map[sessionID]*processEntry
 	workDir     string
 	idleTimeout time.Duration
+	resolve     func(sessionID string) (SessionConfig, error)
+	bus         *events.Bus
+}
+
+// SessionConfig holds per-session settings used when starting an agent
+type SessionConfig struct {
+	WorkDir string
+	// ConversationID is the agent conversation to use (defaults to the
+	// session ID)
+	ConversationID string
 }
 
 type processEntry struct {
@@ -38,21 +49,35 @@ func NewManager(workDir string, idleTimeout time.Duration) *Manager {
 	return m
 }
 
+// SetResolver sets the function used to look up per-session settings for
+// new agents. Without a resolver every agent runs in the default work dir.
+// A resolver error keeps the agent from starting.
+func (m *Manager) SetResolver(resolve func(sessionID string) (SessionConfig, error)) {
+	m.resolve = resolve
+}
+
+// SetEventBus sets the bus on which ended processes are announced
+func (m *Manager) SetEventBus(bus *events.Bus) {
+	m.bus = bus
+}
+
 // GetOrCreate returns an existing agent or creates a new one
 func (m *Manager) GetOrCreate(ctx context.Context, sessionID string) (agent.Agent, error) {
 	// Try to get existing
 	if val, ok := m.processes.Load(sessionID); ok {
 		entry := val.(*processEntry)
 		entry.mu.Lock()
+		defer entry.mu.Unlock()
 		entry.refCount++
 		entry.lastUsed = time.Now()
-		entry.mu.Unlock()
 		return entry.agent, nil
 	}
 
-	// Create new
+	ag, err := m.newAgent(sessionID)
+	if err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithCancel(ctx)
-	ag := claude.New(sessionID, m.workDir)
 
 	entry := &processEntry{
 		agent:     ag,
@@ -67,17 +92,90 @@ func (m *Manager) GetOrCreate(ctx context.Context, sessionID string) (agent.Agen
 	return ag, nil
 }
 
+// newAgent creates an agent with the session's current settings
+func (m *Manager) newAgent(sessionID string) (agent.Agent, error) {
+	workDir := m.workDir
+	conversationID := sessionID
+	if m.resolve != nil {
+		cfg, err := m.resolve(sessionID)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.WorkDir != "" {
+			workDir = cfg.WorkDir
+		}
+		if cfg.ConversationID != "" {
+			conversationID = cfg.ConversationID
+		}
+	}
+
+	return claude.New(conversationID, workDir), nil
+}
+
 // Release decrements the reference count for a session
 func (m *Manager) Release(sessionID string) {
 	if val, ok := m.processes.Load(sessionID); ok {
 		entry := val.(*processEntry)
 		entry.mu.Lock()
-		entry.refCount--
+		if entry.refCount > 0 {
+			entry.refCount--
+		}
 		entry.lastUsed = time.Now()
 		entry.mu.Unlock()
 	}
 }
 
+// IsRunning reports whether the session's agent is processing a message
+func (m *Manager) IsRunning(sessionID string) bool {
+	if val, ok := m.processes.Load(sessionID); ok {
+		entry := val.(*processEntry)
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		return entry.agent.IsRunning()
+	}
+	return false
+}
+
+// InUse reports whether the session's agent is referenced or running
+func (m *Manager) InUse(sessionID string) bool {
+	if val, ok := m.processes.Load(sessionID); ok {
+		entry := val.(*processEntry)
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		return entry.refCount > 0 || entry.agent.IsRunning()
+	}
+	return false
+}
+
+// Restart replaces the session's agent with a fresh one using the session's
+// current settings, e.g. after a rewind moved it to a new conversation. The
+// entry and its reference count are kept, so holders of the old agent still
+// release their references correctly.
+func (m *Manager) Restart(sessionID string) {
+	val, ok := m.processes.Load(sessionID)
+	if !ok {
+		// The next GetOrCreate starts a fresh agent anyway
+		return
+	}
+	entry := val.(*processEntry)
+
+	entry.mu.Lock()
+	ag, err := m.newAgent(sessionID)
+	if err != nil {
+		entry.mu.Unlock()
+		log.Printf("Failed to restart Claude process for session %s: %v", sessionID, err)
+		m.Close(sessionID)
+		return
+	}
+	old := entry.agent
+	entry.agent = ag
+	entry.lastUsed = time.Now()
+	entry.mu.Unlock()
+
+	old.Close()
+	log.Printf("Restarted Claude process for session %s", sessionID)
+}
+
 // Close terminates a specific session's process
 func (m *Manager) Close(sessionID string) {
 	if val, ok := m.processes.LoadAndDelete(sessionID); ok {
@@ -85,6 +183,10 @@ func (m *Manager) Close(sessionID string) {
 		entry.cancelCtx()
 		entry.agent.Close()
 		log.Printf("Closed Claude process for session %s", sessionID)
+		m.bus.Publish(events.Event{
+			Type:      events.ProcessEnded,
+			SessionID: sessionID,
+		})
 	}
 }
 
@@ -0,0 +1,60 @@
+package process
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func refCount(m *Manager, sessionID string) int {
+	val, ok := m.processes.Load(sessionID)
+	if !ok {
+		return -1
+	}
+	entry := val.(*processEntry)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	return entry.refCount
+}
+
+func TestRestartKeepsReferences(t *testing.T) {
+	m := NewManager(t.TempDir(), time.Minute)
+	ctx := context.Background()
+
+	old, err := m.GetOrCreate(ctx, "s1")
+	if err != nil {
+		t.Fatalf("GetOrCreate failed: %v", err)
+	}
+	if _, err := m.GetOrCreate(ctx, "s1"); err != nil {
+		t.Fatalf("GetOrCreate failed: %v", err)
+	}
+
+	m.Restart("s1")
+
+	fresh, err := m.GetOrCreate(ctx, "s1")
+	if err != nil {
+		t.Fatalf("GetOrCreate failed: %v", err)
+	}
+	if fresh == old {
+		t.Error("Expected a new agent after Restart")
+	}
+	if got := refCount(m, "s1"); got != 3 {
+		t.Errorf("Expected 3 references, got %d", got)
+	}
+
+	// Holders of the old agent release into the same entry
+	for i := 0; i < 3; i++ {
+		m.Release("s1")
+	}
+	if got := refCount(m, "s1"); got != 0 {
+		t.Errorf("Expected 0 references, got %d", got)
+	}
+}
+
+func TestRestartUnknownSession(t *testing.T) {
+	m := NewManager(t.TempDir(), time.Minute)
+	m.Restart("missing")
+	if got := refCount(m, "missing"); got != -1 {
+		t.Errorf("Expected no entry, got %d references", got)
+	}
+}
@@ -53,8 +53,15 @@ func PrintTerminalQR(url string) error {
 	return nil
 }
 
-// PrintStartupBanner prints the startup banner with connection URLs
-func PrintStartupBanner(localURL, remoteURL string) {
+// PairingURL returns the URL a device opens to pair with code. The code is
+// in the fragment so it never reaches proxy or relay logs.
+func PairingURL(baseURL, code string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/#pair=" + code
+}
+
+// PrintStartupBanner prints the startup banner with connection URLs. A
+// non-empty pairingCode is shown and embedded in the QR code.
+func PrintStartupBanner(localURL, remoteURL, pairingCode string) {
 	fmt.Println()
 	fmt.Println("╭─────────────────────────────────────────────────────╮")
 	fmt.Println("│                                                     │")
@@ -63,6 +70,11 @@ func PrintStartupBanner(localURL, remoteURL string) {
 	fmt.Printf("│   Local:  %-40s│\n", localURL)
 	if remoteURL != "" {
 		fmt.Printf("│   Remote: %-40s│\n", remoteURL)
+	}
+	if pairingCode != "" {
+		fmt.Printf("│   Pair:   %-40s│\n", pairingCode+" (single use)")
+	}
+	if remoteURL != "" {
 		fmt.Println("│                                                     │")
 		fmt.Println("│   Scan the QR code below to connect:               │")
 		fmt.Println("│                                                     │")
@@ -70,7 +82,11 @@ func PrintStartupBanner(localURL, remoteURL string) {
 	fmt.Println("╰─────────────────────────────────────────────────────╯")
 
 	if remoteURL != "" {
-		if err := PrintTerminalQR(remoteURL); err != nil {
+		qrURL := remoteURL
+		if pairingCode != "" {
+			qrURL = PairingURL(remoteURL, pairingCode)
+		}
+		if err := PrintTerminalQR(qrURL); err != nil {
 			fmt.Printf("  (Failed to generate QR code: %v)\n", err)
 		}
 		fmt.Println()
@@ -217,7 +217,9 @@ func (c *Client) connect(ctx context.Context) error {
 	wsURL = strings.Replace(wsURL, "http://", "ws://", 1)
 	wsURL = fmt.Sprintf("%s/relay", strings.Replace(wsURL, "://", "://"+c.relayConfig.Subdomain+".", 1))
 	log.Printf("Connecting to relay: %s", wsURL)
-	conn, _, err := websocket.Dial(ctx, wsURL, nil)
+	conn, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{
+		CompressionMode: c.cfg.CompressionMode(),
+	})
 	if err != nil {
 		return err
 	}
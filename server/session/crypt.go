@@ -0,0 +1,122 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Noon-R/Devport/server/crypt"
+)
+
+// ErrKeyRequired is returned when reading an encrypted file without a key
+var ErrKeyRequired = errors.New("session file is encrypted but no key is configured")
+
+// NewStoreWithKey creates a session store that encrypts the files it writes
+// with key. Plaintext files written before encryption was enabled are still
+// read, and are encrypted the next time they are saved or by RotateKey.
+func NewStoreWithKey(workDir string, key *crypt.Key) *Store {
+	sessionsDir := filepath.Join(workDir, ".devport", "sessions")
+	os.MkdirAll(sessionsDir, 0755)
+
+	store := &Store{
+		workDir:     workDir,
+		sessionsDir: sessionsDir,
+		key:         key,
+	}
+	store.loadFromDisk()
+
+	return store
+}
+
+// Encrypted reports whether the store encrypts session files
+func (s *Store) Encrypted() bool {
+	return s.key != nil
+}
+
+// readFile reads a session file, decrypting it if needed
+func (s *Store) readFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !crypt.IsEncrypted(data) {
+		return data, err
+	}
+	if s.key == nil {
+		return nil, ErrKeyRequired
+	}
+	return s.key.Open(data)
+}
+
+// writeFile writes a session file, encrypting it if the store has a key
+func (s *Store) writeFile(path string, data []byte) error {
+	if s.key == nil {
+		return os.WriteFile(path, data, 0644)
+	}
+	sealed, err := s.key.Seal(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, sealed, 0600)
+}
+
+// RotateKey re-encrypts every session file under workDir from oldKey to
+// newKey. Files encrypted with oldKey only have their data key rewrapped;
+// plaintext files are encrypted. Passing a nil oldKey encrypts an existing
+// plaintext store. Files already under newKey are skipped, so an interrupted
+// rotation can be rerun. It must not run while a server uses the store.
+func RotateKey(workDir string, oldKey, newKey *crypt.Key) (int, error) {
+	if newKey == nil {
+		return 0, errors.New("new key is required")
+	}
+
+	sessionsDir := filepath.Join(workDir, ".devport", "sessions")
+	rotated := 0
+	err := filepath.WalkDir(sessionsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		// Temp files of an interrupted run are replaced when their session
+		// file is rotated
+		if d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var out []byte
+		switch {
+		case crypt.KeyID(data) == newKey.ID():
+			// Already rotated by an earlier, interrupted run
+			return nil
+		case !crypt.IsEncrypted(data):
+			out, err = newKey.Seal(data)
+		case oldKey == nil:
+			return fmt.Errorf("%s: %w", path, ErrKeyRequired)
+		default:
+			out, err = oldKey.Rewrap(data, newKey)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		// Write through a temp file so an interrupted rotation never leaves
+		// a half-written session file behind
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, out, 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+		rotated++
+		return nil
+	})
+	return rotated, err
+}
@@ -0,0 +1,142 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Noon-R/Devport/server/crypt"
+)
+
+func testKey(t *testing.T, b byte) *crypt.Key {
+	t.Helper()
+	key, err := crypt.NewKey(bytes.Repeat([]byte{b}, crypt.KeySize))
+	if err != nil {
+		t.Fatalf("NewKey failed: %v", err)
+	}
+	return key
+}
+
+// sessionFile reads a file of a session as stored on disk
+func sessionFile(t *testing.T, workDir, sessionID, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(workDir, ".devport", "sessions", sessionID, name))
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return data
+}
+
+func TestPlaintextStoreThenEncrypted(t *testing.T) {
+	workDir := t.TempDir()
+	plain := NewStore(workDir)
+	sess := plain.Create("Plain", "")
+	plain.AddMessage(sess.ID, HistoryMessage{ID: "m1", Role: "user", Content: "hello", Timestamp: time.Now()})
+	if crypt.IsEncrypted(sessionFile(t, workDir, sess.ID, "history.json")) {
+		t.Fatal("Expected a plaintext history")
+	}
+
+	// Plaintext files are still read once a key is configured
+	key := testKey(t, 1)
+	store := NewStoreWithKey(workDir, key)
+	if got := store.Get(sess.ID); got == nil || got.Title != "Plain" {
+		t.Fatalf("Expected the plaintext session, got %+v", got)
+	}
+	if history := store.GetHistory(sess.ID); len(history) != 1 || history[0].Content != "hello" {
+		t.Fatalf("Expected the plaintext history, got %+v", history)
+	}
+
+	// and are encrypted the next time they are saved
+	store.AddMessage(sess.ID, HistoryMessage{ID: "m2", Role: "assistant", Content: "hi", Timestamp: time.Now()})
+	for _, name := range []string{"meta.json", "history.json"} {
+		if data := sessionFile(t, workDir, sess.ID, name); crypt.KeyID(data) != key.ID() {
+			t.Errorf("Expected %s encrypted with the key, got %.80s", name, data)
+		}
+	}
+
+	reopened := NewStoreWithKey(workDir, key)
+	if history := reopened.GetHistory(sess.ID); len(history) != 2 {
+		t.Errorf("Expected 2 messages after reopening, got %d", len(history))
+	}
+
+	// Without the key the encrypted session cannot be read
+	if _, err := NewStore(workDir).readFile(filepath.Join(workDir, ".devport", "sessions", sess.ID, "history.json")); !errors.Is(err, ErrKeyRequired) {
+		t.Errorf("Expected ErrKeyRequired, got %v", err)
+	}
+}
+
+func TestRotateKeyRerun(t *testing.T) {
+	workDir := t.TempDir()
+	oldKey, newKey := testKey(t, 1), testKey(t, 2)
+	store := NewStoreWithKey(workDir, oldKey)
+	var ids []string
+	for _, title := range []string{"A", "B", "C"} {
+		sess := store.Create(title, "")
+		store.AddMessage(sess.ID, HistoryMessage{ID: "m", Role: "user", Content: title, Timestamp: time.Now()})
+		ids = append(ids, sess.ID)
+	}
+
+	// An interrupted rotation: session A is done, B crashed between writing
+	// a temp file and renaming it, C was not reached
+	for _, name := range []string{"meta.json", "history.json"} {
+		path := filepath.Join(workDir, ".devport", "sessions", ids[0], name)
+		rewrapped, err := oldKey.Rewrap(sessionFile(t, workDir, ids[0], name), newKey)
+		if err != nil {
+			t.Fatalf("Rewrap failed: %v", err)
+		}
+		if err := os.WriteFile(path, rewrapped, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmp := filepath.Join(workDir, ".devport", "sessions", ids[1], "history.json.tmp")
+	if err := os.WriteFile(tmp, []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := RotateKey(workDir, oldKey, newKey)
+	if err != nil {
+		t.Fatalf("RotateKey failed: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Expected 4 rotated files, got %d", n)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("Expected the temp file to be replaced, got %v", err)
+	}
+
+	// Running again finds nothing left to do
+	if n, err := RotateKey(workDir, oldKey, newKey); err != nil || n != 0 {
+		t.Errorf("Expected a no-op rerun, got %d, %v", n, err)
+	}
+
+	rotated := NewStoreWithKey(workDir, newKey)
+	for i, id := range ids {
+		history := rotated.GetHistory(id)
+		if len(history) != 1 || history[0].Content != []string{"A", "B", "C"}[i] {
+			t.Errorf("Session %d unreadable under the new key: %+v", i, history)
+		}
+	}
+}
+
+func TestRotateKeyEncryptsPlaintext(t *testing.T) {
+	workDir := t.TempDir()
+	sess := NewStore(workDir).Create("Plain", "")
+	key := testKey(t, 1)
+
+	// A rotation from an encrypted store needs the old key
+	other := NewStoreWithKey(t.TempDir(), testKey(t, 3))
+	other.Create("Sealed", "")
+	if _, err := RotateKey(other.workDir, nil, key); !errors.Is(err, ErrKeyRequired) {
+		t.Errorf("Expected ErrKeyRequired, got %v", err)
+	}
+
+	if n, err := RotateKey(workDir, nil, key); err != nil || n != 1 {
+		t.Fatalf("Expected 1 encrypted file, got %d, %v", n, err)
+	}
+	if got := NewStoreWithKey(workDir, key).Get(sess.ID); got == nil || got.Title != "Plain" {
+		t.Errorf("Expected the session under the key, got %+v", got)
+	}
+}
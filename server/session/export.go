@@ -0,0 +1,280 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// ArchiveFormat identifies a Devport session archive
+const ArchiveFormat = "devport.session"
+
+// ArchiveVersion is the current version of the archive schema. It is
+// independent of the on-disk store format and must be bumped whenever a
+// field is removed or changes meaning.
+//
+// Version 2 replaced the embedded store types with the Archive* types below
+// and dropped machine-local fields such as the working directory.
+const ArchiveVersion = 2
+
+// Archive is the stable, versioned JSON representation of a session. It
+// only holds what is meaningful on another machine.
+type Archive struct {
+	Format     string           `json:"format"`
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Session    ArchiveSession   `json:"session"`
+	Messages   []ArchiveMessage `json:"messages"`
+}
+
+// ArchiveSession is the session metadata of an archive
+type ArchiveSession struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ArchiveMessage is a message of an archive
+type ArchiveMessage struct {
+	ID        string            `json:"id"`
+	Role      string            `json:"role"` // "user", "assistant", "system"
+	Content   string            `json:"content"`
+	ToolCalls []ArchiveToolCall `json:"tool_calls,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// ArchiveToolCall is a tool call of an archived message
+type ArchiveToolCall struct {
+	ID     string                 `json:"id"`
+	Name   string                 `json:"name"`
+	Input  map[string]interface{} `json:"input,omitempty"`
+	Output string                 `json:"output,omitempty"`
+	Status string                 `json:"status"` // "pending", "completed", "error"
+
+	// Set when the output was truncated and the rest is lost
+	OutputTruncated bool `json:"output_truncated,omitempty"`
+	OutputSize      int  `json:"output_size,omitempty"` // original size in bytes
+}
+
+// Export builds an archive of the session and its history. Tool outputs
+// offloaded by compaction are restored in full.
+func (s *Store) Export(sessionID string) (*Archive, error) {
+	session := s.Get(sessionID)
+	if session == nil {
+		return nil, ErrNotFound
+	}
+
+	history := s.GetHistory(sessionID)
+	messages := make([]ArchiveMessage, len(history))
+	for i, msg := range history {
+		messages[i] = archiveMessage(msg)
+		for j, tc := range msg.ToolCalls {
+			if tc.OutputRef == "" {
+				continue
+			}
+			if output, err := s.ReadOutput(sessionID, tc.OutputRef); err == nil {
+				messages[i].ToolCalls[j].Output = string(output)
+				messages[i].ToolCalls[j].OutputTruncated = false
+				messages[i].ToolCalls[j].OutputSize = 0
+			}
+		}
+	}
+
+	return &Archive{
+		Format:     ArchiveFormat,
+		Version:    ArchiveVersion,
+		ExportedAt: time.Now(),
+		Session: ArchiveSession{
+			ID:          session.ID,
+			Title:       session.Title,
+			Description: session.Description,
+			Tags:        session.Tags,
+			CreatedAt:   session.CreatedAt,
+			UpdatedAt:   session.UpdatedAt,
+		},
+		Messages: messages,
+	}, nil
+}
+
+// archiveMessage converts a history message to its archive form
+func archiveMessage(msg HistoryMessage) ArchiveMessage {
+	am := ArchiveMessage{
+		ID:        msg.ID,
+		Role:      msg.Role,
+		Content:   msg.Content,
+		Timestamp: msg.Timestamp,
+	}
+	for _, tc := range msg.ToolCalls {
+		am.ToolCalls = append(am.ToolCalls, ArchiveToolCall{
+			ID:              tc.ID,
+			Name:            tc.Name,
+			Input:           tc.Input,
+			Output:          tc.Output,
+			Status:          tc.Status,
+			OutputTruncated: tc.OutputTruncated,
+			OutputSize:      tc.OutputSize,
+		})
+	}
+	return am
+}
+
+// historyMessage converts an archived message to a history message
+func historyMessage(am ArchiveMessage, seq int64) HistoryMessage {
+	msg := HistoryMessage{
+		ID:        am.ID,
+		Seq:       seq,
+		Role:      am.Role,
+		Content:   am.Content,
+		Timestamp: am.Timestamp,
+	}
+	for _, tc := range am.ToolCalls {
+		msg.ToolCalls = append(msg.ToolCalls, ToolCallInfo{
+			ID:              tc.ID,
+			Name:            tc.Name,
+			Input:           tc.Input,
+			Output:          tc.Output,
+			Status:          tc.Status,
+			OutputTruncated: tc.OutputTruncated,
+			OutputSize:      tc.OutputSize,
+		})
+	}
+	return msg
+}
+
+// WriteJSON writes the archive as indented JSON
+func (a *Archive) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a)
+}
+
+// WriteMarkdown writes the archive as a readable Markdown transcript
+func (a *Archive) WriteMarkdown(w io.Writer) error {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# %s\n\n", a.Session.Title)
+	fmt.Fprintf(&sb, "- Session: `%s`\n", a.Session.ID)
+	fmt.Fprintf(&sb, "- Created: %s\n", a.Session.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "- Exported: %s\n\n", a.ExportedAt.Format(time.RFC3339))
+
+	for _, msg := range a.Messages {
+		sb.WriteString("---\n\n")
+		fmt.Fprintf(&sb, "### %s\n\n", roleLabel(msg.Role))
+		fmt.Fprintf(&sb, "_%s_\n\n", msg.Timestamp.Format(time.RFC3339))
+		if msg.Content != "" {
+			sb.WriteString(msg.Content)
+			sb.WriteString("\n\n")
+		}
+
+		for _, tc := range msg.ToolCalls {
+			fmt.Fprintf(&sb, "<details>\n<summary>Tool: %s (%s)</summary>\n\n", tc.Name, tc.Status)
+			if len(tc.Input) > 0 {
+				input, _ := json.MarshalIndent(tc.Input, "", "  ")
+				sb.WriteString("**Input**\n\n")
+				writeFence(&sb, "json", string(input))
+			}
+			if tc.Output != "" {
+				sb.WriteString("**Output**\n\n")
+				writeFence(&sb, "", tc.Output)
+			}
+			sb.WriteString("</details>\n\n")
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// WriteHTML writes the archive as a standalone HTML page
+func (a *Archive) WriteHTML(w io.Writer) error {
+	type toolView struct {
+		ArchiveToolCall
+		InputJSON string
+	}
+	type messageView struct {
+		ArchiveMessage
+		Label string
+		Tools []toolView
+	}
+
+	messages := make([]messageView, len(a.Messages))
+	for i, msg := range a.Messages {
+		messages[i] = messageView{ArchiveMessage: msg, Label: roleLabel(msg.Role)}
+		for _, tc := range msg.ToolCalls {
+			view := toolView{ArchiveToolCall: tc}
+			if len(tc.Input) > 0 {
+				input, _ := json.MarshalIndent(tc.Input, "", "  ")
+				view.InputJSON = string(input)
+			}
+			messages[i].Tools = append(messages[i].Tools, view)
+		}
+	}
+
+	return htmlTemplate.Execute(w, map[string]interface{}{
+		"Archive":  a,
+		"Messages": messages,
+	})
+}
+
+// roleLabel returns the heading used for a message role
+func roleLabel(role string) string {
+	switch role {
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	case "system":
+		return "System"
+	default:
+		return role
+	}
+}
+
+// writeFence writes content as a fenced code block, using a fence longer
+// than any backtick run inside the content
+func writeFence(sb *strings.Builder, lang, content string) {
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	sb.WriteString(fence + lang + "\n")
+	sb.WriteString(strings.TrimRight(content, "\n"))
+	sb.WriteString("\n" + fence + "\n\n")
+}
+
+var htmlTemplate = template.Must(template.New("session").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Archive.Session.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 860px; margin: 2rem auto; padding: 0 1rem; color: #1f2328; }
+.meta { color: #656d76; font-size: 0.875rem; }
+.message { border-top: 1px solid #d0d7de; padding: 1rem 0; }
+.role { font-weight: 600; }
+.content { white-space: pre-wrap; }
+pre { background: #f6f8fa; padding: 0.75rem; overflow-x: auto; }
+details { margin: 0.5rem 0; }
+</style>
+</head>
+<body>
+<h1>{{.Archive.Session.Title}}</h1>
+<p class="meta">Session {{.Archive.Session.ID}} &middot; created {{.Archive.Session.CreatedAt.Format "2006-01-02T15:04:05Z07:00"}} &middot; exported {{.Archive.ExportedAt.Format "2006-01-02T15:04:05Z07:00"}}</p>
+{{range .Messages}}<div class="message {{.Role}}">
+<div class="role">{{.Label}} <span class="meta">{{.Timestamp.Format "2006-01-02T15:04:05Z07:00"}}</span></div>
+{{if .Content}}<div class="content">{{.Content}}</div>{{end}}
+{{range .Tools}}<details>
+<summary>Tool: {{.Name}} ({{.Status}})</summary>
+{{if .InputJSON}}<p>Input</p><pre>{{.InputJSON}}</pre>{{end}}
+{{if .Output}}<p>Output</p><pre>{{.Output}}</pre>{{end}}
+</details>
+{{end}}</div>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,104 @@
+package session
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	workDir := t.TempDir()
+	store := NewStore(workDir)
+	sess := store.Create("Round trip", "")
+	description, tags := "Exported session", []string{"backend", "export"}
+	if _, err := store.UpdateMetadata(sess.ID, Update{Description: &description, Tags: &tags}); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	output := strings.Repeat("x", 4096)
+	store.AddMessage(sess.ID, HistoryMessage{ID: "u1", Role: "user", Content: "hello", Timestamp: time.Now()})
+	store.AddMessage(sess.ID, HistoryMessage{
+		ID:        "a1",
+		Role:      "assistant",
+		Content:   "hi",
+		ToolCalls: []ToolCallInfo{{ID: "t1", Name: "Read", Output: output, Status: "completed"}},
+		Timestamp: time.Now(),
+	})
+
+	// Offloaded outputs are exported in full
+	if _, err := store.Compact(sess.ID, RetentionPolicy{MaxToolOutput: 100}, time.Time{}); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+
+	archive, err := store.Export(sess.ID)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := archive.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	if strings.Contains(buf.String(), workDir) || strings.Contains(buf.String(), "work_dir") {
+		t.Error("Archive contains the working directory")
+	}
+
+	parsed, err := ParseArchive(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseArchive failed: %v", err)
+	}
+	if parsed.Version != ArchiveVersion || parsed.Session.Title != "Round trip" {
+		t.Errorf("Unexpected archive header: version %d, title %q", parsed.Version, parsed.Session.Title)
+	}
+
+	imported, err := NewStore(t.TempDir()).Import(parsed)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if imported.LastSeq != 2 || !imported.NeedsContext {
+		t.Errorf("Unexpected imported session: %+v", imported)
+	}
+	if imported.Description != description || !slices.Equal(imported.Tags, tags) {
+		t.Errorf("Expected the metadata to survive the round trip, got %q %v", imported.Description, imported.Tags)
+	}
+	tc := parsed.Messages[1].ToolCalls[0]
+	if tc.Output != output || tc.OutputTruncated {
+		t.Errorf("Expected the full tool output, got %d bytes (truncated=%v)", len(tc.Output), tc.OutputTruncated)
+	}
+}
+
+func TestParseArchiveVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{
+			name: "version 1 with store fields",
+			data: `{"format":"devport.session","version":1,"session":{"id":"s","title":"Old","work_dir":"/home/me","last_seq":1},` +
+				`"messages":[{"id":"m","seq":1,"role":"user","content":"hi","timestamp":"2025-01-01T00:00:00Z"}]}`,
+		},
+		{
+			name:    "newer version",
+			data:    `{"format":"devport.session","version":99,"session":{},"messages":[]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := ParseArchive([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseArchive failed: %v", err)
+			}
+			if len(archive.Messages) != 1 || archive.Messages[0].Content != "hi" {
+				t.Errorf("Unexpected messages: %+v", archive.Messages)
+			}
+		})
+	}
+}
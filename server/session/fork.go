@@ -0,0 +1,167 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Noon-R/Devport/server/events"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotFound is returned when a session does not exist
+	ErrNotFound = errors.New("session not found")
+	// ErrMessageNotFound is returned when a message is not in the session history
+	ErrMessageNotFound = errors.New("message not found")
+)
+
+// Fork creates a new session whose history is copied from sessionID up to
+// and including messageID. The new session starts a fresh agent
+// conversation that is seeded with the copied history on its first message.
+func (s *Store) Fork(sessionID, messageID string) (*Session, error) {
+	parent := s.Get(sessionID)
+	if parent == nil {
+		return nil, ErrNotFound
+	}
+
+	defer s.lock(sessionID)()
+	history := s.GetHistory(sessionID)
+	end := indexOf(history, messageID)
+	if end < 0 {
+		return nil, ErrMessageNotFound
+	}
+	return s.fork(parent, history[:end+1], messageID)
+}
+
+// ForkBefore is like Fork but leaves messageID itself out of the copied
+// history, so that the new session can continue with a different message
+func (s *Store) ForkBefore(sessionID, messageID string) (*Session, error) {
+	parent := s.Get(sessionID)
+	if parent == nil {
+		return nil, ErrNotFound
+	}
+
+	defer s.lock(sessionID)()
+	history := s.GetHistory(sessionID)
+	end := indexOf(history, messageID)
+	if end < 0 {
+		return nil, ErrMessageNotFound
+	}
+	return s.fork(parent, history[:end], messageID)
+}
+
+func (s *Store) fork(parent *Session, history []HistoryMessage, messageID string) (*Session, error) {
+	forked := make([]HistoryMessage, len(history))
+	copy(forked, history)
+
+	// Offloaded tool outputs are looked up in the session's own directory
+	id := uuid.New().String()
+	if err := s.copyOutputs(parent.ID, id, forked); err != nil {
+		os.RemoveAll(filepath.Join(s.sessionsDir, id))
+		return nil, err
+	}
+
+	session := &Session{
+		ID:            id,
+		Title:         parent.Title + " (fork)",
+		WorkDir:       parent.WorkDir,
+		ForkedFrom:    parent.ID,
+		ForkMessageID: messageID,
+		NeedsContext:  len(forked) > 0,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	if n := len(forked); n > 0 {
+		session.LastSeq = forked[n-1].Seq
+	}
+	s.sessions.Store(session.ID, session)
+	s.histories.Store(session.ID, forked)
+
+	s.saveSessionToDisk(session)
+	s.saveHistoryToDisk(session.ID, forked)
+	s.emit(events.SessionCreated, session)
+
+	return session, nil
+}
+
+// copyOutputs copies the tool outputs of history offloaded by Compact from
+// one session to another; the caller holds the lock of the source session
+func (s *Store) copyOutputs(fromID, toID string, history []HistoryMessage) error {
+	for _, msg := range history {
+		for _, tc := range msg.ToolCalls {
+			if tc.OutputRef == "" {
+				continue
+			}
+			output, err := s.ReadOutput(fromID, tc.OutputRef)
+			if err != nil {
+				return fmt.Errorf("copy tool output %s: %w", tc.OutputRef, err)
+			}
+			if err := s.writeOutput(toID, tc.OutputRef, string(output)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func indexOf(history []HistoryMessage, messageID string) int {
+	for i, msg := range history {
+		if msg.ID == messageID {
+			return i
+		}
+	}
+	return -1
+}
+
+// PendingContext returns the history that must be replayed into the
+// session's agent conversation before the next message, or nil if the agent
+// already has it. Call ContextSent once the agent accepted the message
+// carrying it, so that a failed send is seeded again.
+func (s *Store) PendingContext(sessionID string) []HistoryMessage {
+	session := s.Get(sessionID)
+	if session == nil || !session.NeedsContext {
+		return nil
+	}
+	return s.GetHistory(sessionID)
+}
+
+// ContextSent records that the agent has been given the history
+func (s *Store) ContextSent(sessionID string) {
+	defer s.lock(sessionID)()
+	session := s.Get(sessionID)
+	if session == nil || !session.NeedsContext {
+		return
+	}
+	session.NeedsContext = false
+	s.saveSessionToDisk(session)
+}
+
+// SeedPrompt prefixes message with a transcript of history so that a fresh
+// agent conversation can continue where the original one left off
+func SeedPrompt(history []HistoryMessage, message string) string {
+	if len(history) == 0 {
+		return message
+	}
+
+	var sb strings.Builder
+	sb.WriteString("The following is the transcript of an earlier conversation. Continue it from the last message.\n\n")
+	sb.WriteString("<transcript>\n")
+	for _, msg := range history {
+		fmt.Fprintf(&sb, "[%s]\n", msg.Role)
+		if msg.Content != "" {
+			sb.WriteString(msg.Content)
+			sb.WriteString("\n")
+		}
+		for _, tc := range msg.ToolCalls {
+			fmt.Fprintf(&sb, "(tool %s: %s)\n", tc.Name, tc.Status)
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("</transcript>\n\n")
+	sb.WriteString(message)
+	return sb.String()
+}
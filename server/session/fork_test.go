@@ -0,0 +1,69 @@
+package session
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPendingContextUntilSent(t *testing.T) {
+	store := NewStore(t.TempDir())
+	parent := store.Create("Parent", "")
+	msg := store.AddMessage(parent.ID, HistoryMessage{ID: "m1", Role: "user", Content: "hello"})
+
+	forked, err := store.Fork(parent.ID, msg.ID)
+	if err != nil {
+		t.Fatalf("Fork failed: %v", err)
+	}
+
+	// A failed send leaves the context pending for the next attempt
+	for i := 0; i < 2; i++ {
+		if history := store.PendingContext(forked.ID); len(history) != 1 {
+			t.Fatalf("Attempt %d: expected 1 pending message, got %d", i, len(history))
+		}
+	}
+
+	store.ContextSent(forked.ID)
+	if history := store.PendingContext(forked.ID); history != nil {
+		t.Errorf("Expected no pending context after sending, got %d messages", len(history))
+	}
+}
+
+func TestForkCompactedSession(t *testing.T) {
+	store := NewStore(t.TempDir())
+	parent := store.Create("Parent", "")
+	output := strings.Repeat("x", 4096)
+	store.AddMessage(parent.ID, HistoryMessage{
+		ID:        "m1",
+		Role:      "assistant",
+		ToolCalls: []ToolCallInfo{{ID: "t1", Name: "Read", Output: output, Status: "completed"}},
+	})
+	if _, err := store.Compact(parent.ID, RetentionPolicy{MaxToolOutput: 100}, time.Time{}); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+
+	forked, err := store.Fork(parent.ID, "m1")
+	if err != nil {
+		t.Fatalf("Fork failed: %v", err)
+	}
+	tc := store.GetHistory(forked.ID)[0].ToolCalls[0]
+	if tc.OutputRef == "" {
+		t.Fatal("Expected the forked tool call to keep its output reference")
+	}
+	data, err := store.ReadOutput(forked.ID, tc.OutputRef)
+	if err != nil || string(data) != output {
+		t.Fatalf("Expected the offloaded output in the fork, got %d bytes, %v", len(data), err)
+	}
+
+	// The fork's copy outlives the parent
+	if err := store.Delete(parent.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	archive, err := store.Export(forked.ID)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if got := archive.Messages[0].ToolCalls[0].Output; got != output {
+		t.Errorf("Expected the full output in the export, got %d bytes", len(got))
+	}
+}
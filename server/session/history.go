@@ -0,0 +1,87 @@
+package session
+
+import "sort"
+
+// Page is a window of a session history
+type Page struct {
+	Messages []HistoryMessage `json:"messages"`
+	HasMore  bool             `json:"has_more"` // more messages exist beyond the page
+	LastSeq  int64            `json:"last_seq"` // latest sequence number in the session
+}
+
+// PageOptions selects a window of history by sequence number. With After
+// set, the page holds the oldest messages newer than After; with Before
+// set, the newest messages older than Before; otherwise the latest messages.
+// A Limit of zero returns every matching message.
+type PageOptions struct {
+	After  int64
+	Before int64
+	Limit  int
+}
+
+// GetHistoryPage returns a page of the session history
+func (s *Store) GetHistoryPage(sessionID string, opts PageOptions) Page {
+	history := s.GetHistory(sessionID)
+
+	var lastSeq int64
+	if session := s.Get(sessionID); session != nil {
+		lastSeq = session.LastSeq
+	}
+
+	// History is ordered by Seq, so cursors can be binary searched
+	start, end := 0, len(history)
+	if opts.After > 0 {
+		start = sort.Search(len(history), func(i int) bool { return history[i].Seq > opts.After })
+	}
+	if opts.Before > 0 {
+		end = sort.Search(len(history), func(i int) bool { return history[i].Seq >= opts.Before })
+	}
+	if start > end {
+		start = end
+	}
+
+	hasMore := false
+	if opts.Limit > 0 && end-start > opts.Limit {
+		hasMore = true
+		if opts.After > 0 && opts.Before == 0 {
+			end = start + opts.Limit
+		} else {
+			start = end - opts.Limit
+		}
+	}
+
+	messages := make([]HistoryMessage, end-start)
+	copy(messages, history[start:end])
+
+	return Page{
+		Messages: messages,
+		HasMore:  hasMore,
+		LastSeq:  lastSeq,
+	}
+}
+
+// FindSeq returns the sequence number of a message, or false if the message
+// is not in the history
+func (s *Store) FindSeq(sessionID, messageID string) (int64, bool) {
+	for _, msg := range s.GetHistory(sessionID) {
+		if msg.ID == messageID {
+			return msg.Seq, true
+		}
+	}
+	return 0, false
+}
+
+// renumber assigns sequence numbers to messages that lack them so that the
+// history is strictly increasing. It reports whether anything changed.
+func renumber(history []HistoryMessage) bool {
+	changed := false
+	var prev int64
+	for i := range history {
+		if history[i].Seq <= prev {
+			history[i].Seq = prev + 1
+			changed = true
+		}
+		prev = history[i].Seq
+	}
+	return changed
+}
@@ -0,0 +1,99 @@
+package session
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func seqs(messages []HistoryMessage) []int64 {
+	result := []int64{}
+	for _, msg := range messages {
+		result = append(result, msg.Seq)
+	}
+	return result
+}
+
+func TestGetHistoryPage(t *testing.T) {
+	store := NewStore(t.TempDir())
+	sess := store.Create("Paged", "")
+	for i := 1; i <= 10; i++ {
+		store.AddMessage(sess.ID, HistoryMessage{ID: fmt.Sprintf("m%d", i), Role: "user"})
+	}
+
+	tests := []struct {
+		name        string
+		opts        PageOptions
+		want        []int64
+		wantHasMore bool
+	}{
+		{"everything", PageOptions{}, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, false},
+		{"latest", PageOptions{Limit: 3}, []int64{8, 9, 10}, true},
+		{"limit equals history", PageOptions{Limit: 10}, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, false},
+		{"limit beyond history", PageOptions{Limit: 50}, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, false},
+		{"before", PageOptions{Before: 8, Limit: 3}, []int64{5, 6, 7}, true},
+		{"before reaches the start", PageOptions{Before: 4, Limit: 3}, []int64{1, 2, 3}, false},
+		{"before the first", PageOptions{Before: 1, Limit: 3}, []int64{}, false},
+		{"before beyond the last", PageOptions{Before: 99, Limit: 2}, []int64{9, 10}, true},
+		{"after", PageOptions{After: 2, Limit: 3}, []int64{3, 4, 5}, true},
+		{"after reaches the end", PageOptions{After: 7, Limit: 3}, []int64{8, 9, 10}, false},
+		{"after the last", PageOptions{After: 10, Limit: 3}, []int64{}, false},
+		{"after without limit", PageOptions{After: 8}, []int64{9, 10}, false},
+		{"between", PageOptions{After: 2, Before: 9}, []int64{3, 4, 5, 6, 7, 8}, false},
+		{"between with limit keeps the newest", PageOptions{After: 2, Before: 9, Limit: 2}, []int64{7, 8}, true},
+		{"crossed cursors", PageOptions{After: 8, Before: 3}, []int64{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := store.GetHistoryPage(sess.ID, tt.opts)
+			if got := seqs(page.Messages); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			if page.HasMore != tt.wantHasMore {
+				t.Errorf("Expected has_more %v, got %v", tt.wantHasMore, page.HasMore)
+			}
+			if page.LastSeq != 10 {
+				t.Errorf("Expected last_seq 10, got %d", page.LastSeq)
+			}
+		})
+	}
+
+	// Pages are copies of the history
+	page := store.GetHistoryPage(sess.ID, PageOptions{Limit: 1})
+	page.Messages[0].Content = "changed"
+	if store.GetHistory(sess.ID)[9].Content == "changed" {
+		t.Error("Modifying a page changed the history")
+	}
+
+	if page := store.GetHistoryPage("missing", PageOptions{Limit: 5}); len(page.Messages) != 0 || page.HasMore || page.LastSeq != 0 {
+		t.Errorf("Expected an empty page for an unknown session, got %+v", page)
+	}
+}
+
+func TestRenumber(t *testing.T) {
+	tests := []struct {
+		name        string
+		seqs        []int64
+		want        []int64
+		wantChanged bool
+	}{
+		{"numbered", []int64{1, 2, 5}, []int64{1, 2, 5}, false},
+		{"unnumbered", []int64{0, 0, 0}, []int64{1, 2, 3}, true},
+		{"partly numbered", []int64{1, 0, 4, 4}, []int64{1, 2, 4, 5}, true},
+		{"empty", nil, []int64{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := make([]HistoryMessage, len(tt.seqs))
+			for i, seq := range tt.seqs {
+				history[i].Seq = seq
+			}
+			if changed := renumber(history); changed != tt.wantChanged {
+				t.Errorf("Expected changed %v, got %v", tt.wantChanged, changed)
+			}
+			if got := seqs(history); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
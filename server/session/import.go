@@ -0,0 +1,292 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Noon-R/Devport/server/events"
+	"github.com/google/uuid"
+)
+
+// ErrUnsupportedArchive is returned when import data is neither a Devport
+// archive nor a Claude CLI transcript
+var ErrUnsupportedArchive = errors.New("unsupported archive format")
+
+// Import creates a new session from an archive. The session gets a fresh ID
+// and its agent is seeded with the imported history on the first message.
+func (s *Store) Import(archive *Archive) (*Session, error) {
+	title := archive.Session.Title
+	if title == "" {
+		title = "Imported Chat"
+	}
+
+	createdAt := archive.Session.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	history := make([]HistoryMessage, len(archive.Messages))
+	for i, msg := range archive.Messages {
+		history[i] = historyMessage(msg, int64(i+1))
+	}
+
+	session := &Session{
+		ID:           uuid.New().String(),
+		Title:        title,
+		Description:  archive.Session.Description,
+		Tags:         normalizeTags(archive.Session.Tags),
+		WorkDir:      s.workDir,
+		NeedsContext: len(history) > 0,
+		LastSeq:      int64(len(history)),
+		CreatedAt:    createdAt,
+		UpdatedAt:    time.Now(),
+	}
+	s.sessions.Store(session.ID, session)
+	s.histories.Store(session.ID, history)
+
+	s.saveSessionToDisk(session)
+	s.saveHistoryToDisk(session.ID, history)
+	s.emit(events.SessionCreated, session)
+
+	return session, nil
+}
+
+// ParseArchive detects and parses either a Devport JSON archive or a Claude
+// CLI JSONL transcript
+func ParseArchive(data []byte) (*Archive, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, ErrUnsupportedArchive
+	}
+
+	var archive Archive
+	if err := json.Unmarshal(trimmed, &archive); err == nil && archive.Format == ArchiveFormat {
+		// Version 1 archives decode into the current types; the store
+		// fields they also carried are ignored
+		if archive.Version > ArchiveVersion {
+			return nil, fmt.Errorf("archive version %d is newer than supported version %d", archive.Version, ArchiveVersion)
+		}
+		return &archive, nil
+	}
+
+	return ParseClaudeTranscript(bytes.NewReader(trimmed))
+}
+
+// claudeTranscriptLine is a single entry of a Claude CLI transcript
+// (~/.claude/projects/<project>/<session>.jsonl)
+type claudeTranscriptLine struct {
+	Type      string          `json:"type"`
+	UUID      string          `json:"uuid"`
+	Timestamp time.Time       `json:"timestamp"`
+	Summary   string          `json:"summary"`
+	Content   string          `json:"content"`
+	Message   json.RawMessage `json:"message"`
+}
+
+type claudeMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type claudeContentBlock struct {
+	Type      string                 `json:"type"`
+	Text      string                 `json:"text"`
+	ID        string                 `json:"id"`
+	Name      string                 `json:"name"`
+	Input     map[string]interface{} `json:"input"`
+	ToolUseID string                 `json:"tool_use_id"`
+	Content   json.RawMessage        `json:"content"`
+	IsError   bool                   `json:"is_error"`
+}
+
+// ParseClaudeTranscript reconstructs a session history from a Claude CLI
+// JSONL transcript. Consecutive assistant entries are merged into a single
+// message and tool results are attached to the tool call that produced them.
+func ParseClaudeTranscript(r io.Reader) (*Archive, error) {
+	archive := &Archive{
+		Format:  ArchiveFormat,
+		Version: ArchiveVersion,
+	}
+
+	var messages []HistoryMessage
+	var current *HistoryMessage // assistant message being assembled
+	toolIndex := map[string]*ToolCallInfo{}
+
+	flush := func() {
+		if current != nil {
+			messages = append(messages, *current)
+			current = nil
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 10*1024*1024)
+
+	parsed := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		// Lines the CLI did not finish writing are skipped; input with no
+		// transcript entries at all is rejected below
+		var entry claudeTranscriptLine
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+		switch entry.Type {
+		case "summary", "user", "assistant", "system":
+			parsed++
+		}
+
+		if archive.Session.CreatedAt.IsZero() && !entry.Timestamp.IsZero() {
+			archive.Session.CreatedAt = entry.Timestamp
+		}
+
+		switch entry.Type {
+		case "summary":
+			if archive.Session.Title == "" {
+				archive.Session.Title = entry.Summary
+			}
+
+		case "user":
+			var msg claudeMessage
+			if err := json.Unmarshal(entry.Message, &msg); err != nil {
+				continue
+			}
+			text, blocks := decodeClaudeContent(msg.Content)
+
+			// Tool results arrive as user entries; attach them to their call
+			for _, block := range blocks {
+				if block.Type != "tool_result" {
+					continue
+				}
+				if tc, ok := toolIndex[block.ToolUseID]; ok {
+					output, _ := decodeClaudeContent(block.Content)
+					tc.Output = output
+					tc.Status = "completed"
+					if block.IsError {
+						tc.Status = "error"
+					}
+				}
+			}
+
+			if text == "" {
+				continue
+			}
+			flush()
+			messages = append(messages, HistoryMessage{
+				ID:        messageID(entry.UUID),
+				Role:      "user",
+				Content:   text,
+				Timestamp: entry.Timestamp,
+			})
+
+		case "assistant":
+			var msg claudeMessage
+			if err := json.Unmarshal(entry.Message, &msg); err != nil {
+				continue
+			}
+			text, blocks := decodeClaudeContent(msg.Content)
+
+			if current == nil {
+				current = &HistoryMessage{
+					ID:        messageID(entry.UUID),
+					Role:      "assistant",
+					Timestamp: entry.Timestamp,
+				}
+			}
+			if text != "" {
+				if current.Content != "" {
+					current.Content += "\n\n"
+				}
+				current.Content += text
+			}
+			for _, block := range blocks {
+				if block.Type != "tool_use" {
+					continue
+				}
+				current.ToolCalls = append(current.ToolCalls, ToolCallInfo{
+					ID:     block.ID,
+					Name:   block.Name,
+					Input:  block.Input,
+					Status: "pending",
+				})
+			}
+			// Re-index since append may have moved the slice
+			for i := range current.ToolCalls {
+				toolIndex[current.ToolCalls[i].ID] = &current.ToolCalls[i]
+			}
+
+		case "system":
+			if entry.Content == "" {
+				continue
+			}
+			flush()
+			messages = append(messages, HistoryMessage{
+				ID:        messageID(entry.UUID),
+				Role:      "system",
+				Content:   entry.Content,
+				Timestamp: entry.Timestamp,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	if parsed == 0 {
+		return nil, ErrUnsupportedArchive
+	}
+
+	if archive.Session.Title == "" {
+		archive.Session.Title = HeuristicTitle(messages)
+	}
+	archive.Messages = make([]ArchiveMessage, len(messages))
+	for i, msg := range messages {
+		archive.Messages[i] = archiveMessage(msg)
+	}
+	return archive, nil
+}
+
+// decodeClaudeContent returns the concatenated text of a content field,
+// which is either a plain string or a list of content blocks
+func decodeClaudeContent(raw json.RawMessage) (string, []claudeContentBlock) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var blocks []claudeContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "", nil
+	}
+
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "text" && block.Text != "" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n"), blocks
+}
+
+// messageID keeps the transcript's ID when present
+func messageID(id string) string {
+	if id != "" {
+		return id
+	}
+	return uuid.New().String()
+}
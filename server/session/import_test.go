@@ -0,0 +1,149 @@
+package session
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func parseTranscript(t *testing.T, name string) (*Archive, error) {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to open fixture: %v", err)
+	}
+	defer f.Close()
+	return ParseClaudeTranscript(f)
+}
+
+func TestParseClaudeTranscriptToolCalls(t *testing.T) {
+	archive, err := parseTranscript(t, "tool_use.jsonl")
+	if err != nil {
+		t.Fatalf("ParseClaudeTranscript failed: %v", err)
+	}
+	if archive.Session.Title != "Fix the failing build" {
+		t.Errorf("Expected the summary as title, got %q", archive.Session.Title)
+	}
+	if want := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC); !archive.Session.CreatedAt.Equal(want) {
+		t.Errorf("Expected the first timestamp as creation time, got %v", archive.Session.CreatedAt)
+	}
+
+	// Assistant entries of one turn merge, tool results join their calls
+	// and a result for an unknown call is dropped
+	want := []struct {
+		id, role, content string
+	}{
+		{"u1", "user", "Why does the build fail?"},
+		{"a1", "assistant", "Let me look at the Makefile.\n\nfoo is not defined."},
+		{"s1", "system", "Conversation compacted"},
+		{"u2", "user", "Thanks"},
+	}
+	if len(archive.Messages) != len(want) {
+		t.Fatalf("Expected %d messages, got %d: %+v", len(want), len(archive.Messages), archive.Messages)
+	}
+	for i, w := range want {
+		msg := archive.Messages[i]
+		if msg.ID != w.id || msg.Role != w.role || msg.Content != w.content {
+			t.Errorf("Message %d: expected %s %s %q, got %s %s %q", i, w.id, w.role, w.content, msg.ID, msg.Role, msg.Content)
+		}
+	}
+
+	calls := archive.Messages[1].ToolCalls
+	wantCalls := []ArchiveToolCall{
+		{ID: "toolu_1", Name: "Read", Output: "build:\n\tgo build ./...", Status: "completed"},
+		{ID: "toolu_2", Name: "Bash", Output: "undefined: foo", Status: "error"},
+	}
+	if len(calls) != len(wantCalls) {
+		t.Fatalf("Expected %d tool calls, got %+v", len(wantCalls), calls)
+	}
+	for i, w := range wantCalls {
+		tc := calls[i]
+		if tc.ID != w.ID || tc.Name != w.Name || tc.Output != w.Output || tc.Status != w.Status {
+			t.Errorf("Tool call %d: expected %+v, got %+v", i, w, tc)
+		}
+	}
+	if calls[0].Input["file_path"] != "Makefile" {
+		t.Errorf("Expected the tool input, got %v", calls[0].Input)
+	}
+}
+
+func TestParseClaudeTranscriptMalformedLines(t *testing.T) {
+	archive, err := parseTranscript(t, "malformed.jsonl")
+	if err != nil {
+		t.Fatalf("ParseClaudeTranscript failed: %v", err)
+	}
+	if len(archive.Messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %+v", archive.Messages)
+	}
+	if got := archive.Messages[1]; got.Role != "assistant" || got.Content != "hi there" {
+		t.Errorf("Unexpected assistant message: %+v", got)
+	}
+	if archive.Session.Title == "" {
+		t.Error("Expected a title from the history")
+	}
+}
+
+func TestParseClaudeTranscriptRejects(t *testing.T) {
+	for _, name := range []string{"empty.jsonl", "no_entries.jsonl"} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseTranscript(t, name); !errors.Is(err, ErrUnsupportedArchive) {
+				t.Errorf("Expected ErrUnsupportedArchive, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseArchiveDetectsTranscript(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "tool_use.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	archive, err := ParseArchive(data)
+	if err != nil {
+		t.Fatalf("ParseArchive failed: %v", err)
+	}
+	if archive.Format != ArchiveFormat || len(archive.Messages) != 4 {
+		t.Errorf("Expected a converted transcript, got %s with %d messages", archive.Format, len(archive.Messages))
+	}
+
+	imported, err := NewStore(t.TempDir()).Import(archive)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if imported.Title != "Fix the failing build" || imported.LastSeq != 4 {
+		t.Errorf("Unexpected imported session: %+v", imported)
+	}
+}
+
+func TestImportArchiveFixture(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "archive.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	archive, err := ParseArchive(data)
+	if err != nil {
+		t.Fatalf("ParseArchive failed: %v", err)
+	}
+
+	store := NewStore(t.TempDir())
+	imported, err := store.Import(archive)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if imported.ID == archive.Session.ID {
+		t.Error("Expected the import to get a fresh ID")
+	}
+	if imported.Title != "Release checklist" || imported.Description != "Steps for the 1.2 release" {
+		t.Errorf("Unexpected title or description: %q, %q", imported.Title, imported.Description)
+	}
+	if !slices.Equal(imported.Tags, []string{"release", "ops"}) {
+		t.Errorf("Expected the archive's tags, got %v", imported.Tags)
+	}
+	history := store.GetHistory(imported.ID)
+	if len(history) != 2 || history[1].ToolCalls[0].Output != "## 1.2" {
+		t.Errorf("Unexpected history: %+v", history)
+	}
+}
@@ -0,0 +1,185 @@
+package session
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Noon-R/Devport/server/events"
+)
+
+// Archived filter values for ListOptions
+const (
+	ArchivedExclude = "exclude"
+	ArchivedInclude = "include"
+	ArchivedOnly    = "only"
+)
+
+// Sort keys for ListOptions
+const (
+	SortUpdatedAt = "updated_at"
+	SortCreatedAt = "created_at"
+	SortTitle     = "title"
+)
+
+// ListOptions filters, sorts and paginates session listings
+type ListOptions struct {
+	Tags      []string // sessions must carry every tag
+	Pinned    *bool
+	Archived  string // ArchivedExclude (default), ArchivedInclude or ArchivedOnly
+	Search    string // case-insensitive match on title and description
+	SortBy    string // SortUpdatedAt (default), SortCreatedAt or SortTitle
+	Ascending bool
+	Offset    int
+	Limit     int // 0 means no limit
+}
+
+// Update holds the metadata fields to change; nil fields are left as is
+type Update struct {
+	Title       *string
+	Description *string
+	Tags        *[]string
+	Pinned      *bool
+	Archived    *bool
+}
+
+// Query returns the sessions matching opts and the total number of matches
+// before pagination. Pinned sessions are always listed first.
+func (s *Store) Query(opts ListOptions) ([]*Session, int) {
+	search := strings.ToLower(opts.Search)
+
+	sessions := []*Session{}
+	s.sessions.Range(func(key, value interface{}) bool {
+		session := value.(*Session)
+		if matches(session, &opts, search) {
+			sessions = append(sessions, session)
+		}
+		return true
+	})
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		a, b := sessions[i], sessions[j]
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if opts.Ascending {
+			return sortLess(a, b, opts.SortBy)
+		}
+		return sortLess(b, a, opts.SortBy)
+	})
+
+	total := len(sessions)
+	if opts.Offset > 0 {
+		if opts.Offset >= total {
+			return []*Session{}, total
+		}
+		sessions = sessions[opts.Offset:]
+	}
+	if opts.Limit > 0 && len(sessions) > opts.Limit {
+		sessions = sessions[:opts.Limit]
+	}
+	return sessions, total
+}
+
+// sortLess orders sessions by key in ascending order
+func sortLess(a, b *Session, key string) bool {
+	switch key {
+	case SortCreatedAt:
+		return a.CreatedAt.Before(b.CreatedAt)
+	case SortTitle:
+		return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+	default:
+		return a.UpdatedAt.Before(b.UpdatedAt)
+	}
+}
+
+func matches(session *Session, opts *ListOptions, search string) bool {
+	switch opts.Archived {
+	case ArchivedInclude:
+	case ArchivedOnly:
+		if !session.Archived {
+			return false
+		}
+	default:
+		if session.Archived {
+			return false
+		}
+	}
+
+	if opts.Pinned != nil && session.Pinned != *opts.Pinned {
+		return false
+	}
+
+	for _, tag := range opts.Tags {
+		if !hasTag(session.Tags, tag) {
+			return false
+		}
+	}
+
+	if search != "" &&
+		!strings.Contains(strings.ToLower(session.Title), search) &&
+		!strings.Contains(strings.ToLower(session.Description), search) {
+		return false
+	}
+
+	return true
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateMetadata applies update to the session and persists it
+func (s *Store) UpdateMetadata(id string, update Update) (*Session, error) {
+	defer s.lock(id)()
+	session := s.Get(id)
+	if session == nil {
+		return nil, ErrNotFound
+	}
+
+	if update.Title != nil {
+		session.Title = *update.Title
+		session.TitlePending = false
+	}
+	if update.Description != nil {
+		session.Description = *update.Description
+	}
+	if update.Tags != nil {
+		session.Tags = normalizeTags(*update.Tags)
+	}
+	if update.Pinned != nil {
+		session.Pinned = *update.Pinned
+	}
+	if update.Archived != nil && *update.Archived != session.Archived {
+		session.Archived = *update.Archived
+		if session.Archived {
+			now := time.Now()
+			session.ArchivedAt = &now
+		} else {
+			session.ArchivedAt = nil
+		}
+	}
+	session.UpdatedAt = time.Now()
+	s.saveSessionToDisk(session)
+	s.emit(events.SessionUpdated, session)
+
+	return session, nil
+}
+
+// normalizeTags trims tags and drops empty and duplicate entries
+func normalizeTags(tags []string) []string {
+	var result []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || hasTag(result, tag) {
+			continue
+		}
+		result = append(result, tag)
+	}
+	return result
+}
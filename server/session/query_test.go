@@ -0,0 +1,146 @@
+package session
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// queryFixture creates sessions titled A to E with fixed metadata
+func queryFixture(t *testing.T) *Store {
+	t.Helper()
+	store := NewStore(t.TempDir())
+	base := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	fixtures := []struct {
+		title, description string
+		tags               []string
+		pinned, archived   bool
+		created, updated   int // hours after base
+	}{
+		{"A", "deploy scripts", []string{"ops"}, false, false, 0, 4},
+		{"b", "", []string{"ops", "Backend"}, true, false, 1, 1},
+		{"C", "Flaky test", []string{"backend"}, false, false, 2, 3},
+		{"D", "", nil, false, true, 3, 5},
+		{"E", "old deploy", []string{"ops"}, false, true, 4, 0},
+	}
+	for _, f := range fixtures {
+		sess := store.Create(f.title, "")
+		tags := f.tags
+		if _, err := store.UpdateMetadata(sess.ID, Update{
+			Description: &f.description,
+			Tags:        &tags,
+			Pinned:      &f.pinned,
+			Archived:    &f.archived,
+		}); err != nil {
+			t.Fatalf("UpdateMetadata failed: %v", err)
+		}
+		sess.CreatedAt = base.Add(time.Duration(f.created) * time.Hour)
+		sess.UpdatedAt = base.Add(time.Duration(f.updated) * time.Hour)
+	}
+	return store
+}
+
+func titles(sessions []*Session) []string {
+	result := make([]string, len(sessions))
+	for i, s := range sessions {
+		result[i] = s.Title
+	}
+	return result
+}
+
+func TestQuery(t *testing.T) {
+	store := queryFixture(t)
+	yes, no := true, false
+
+	tests := []struct {
+		name      string
+		opts      ListOptions
+		want      []string
+		wantTotal int
+	}{
+		{"default hides archived, newest first, pinned on top", ListOptions{}, []string{"b", "A", "C"}, 3},
+		{"include archived", ListOptions{Archived: ArchivedInclude}, []string{"b", "D", "A", "C", "E"}, 5},
+		{"only archived", ListOptions{Archived: ArchivedOnly}, []string{"D", "E"}, 2},
+		{"tag matches case-insensitively", ListOptions{Tags: []string{"BACKEND"}}, []string{"b", "C"}, 2},
+		{"every tag required", ListOptions{Tags: []string{"ops", "backend"}}, []string{"b"}, 1},
+		{"pinned", ListOptions{Pinned: &yes}, []string{"b"}, 1},
+		{"not pinned", ListOptions{Pinned: &no}, []string{"A", "C"}, 2},
+		{"search title", ListOptions{Search: "B"}, []string{"b"}, 1},
+		{"search description", ListOptions{Search: "DEPLOY", Archived: ArchivedInclude}, []string{"A", "E"}, 2},
+		{"created ascending", ListOptions{SortBy: SortCreatedAt, Ascending: true, Archived: ArchivedInclude}, []string{"b", "A", "C", "D", "E"}, 5},
+		{"title descending", ListOptions{SortBy: SortTitle, Archived: ArchivedInclude}, []string{"b", "E", "D", "C", "A"}, 5},
+		{"title ascending ignores case", ListOptions{SortBy: SortTitle, Ascending: true}, []string{"b", "A", "C"}, 3},
+		{"offset and limit", ListOptions{Archived: ArchivedInclude, Offset: 1, Limit: 2}, []string{"D", "A"}, 5},
+		{"limit beyond total", ListOptions{Limit: 10}, []string{"b", "A", "C"}, 3},
+		{"offset at total", ListOptions{Offset: 3}, []string{}, 3},
+		{"offset beyond total", ListOptions{Offset: 10}, []string{}, 3},
+		{"no match", ListOptions{Tags: []string{"missing"}}, []string{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions, total := store.Query(tt.opts)
+			if got := titles(sessions); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("Expected total %d, got %d", tt.wantTotal, total)
+			}
+		})
+	}
+}
+
+func TestQueryTies(t *testing.T) {
+	store := NewStore(t.TempDir())
+	same := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, title := range []string{"same", "same", "same", "same"} {
+		sess := store.Create(title, "")
+		sess.UpdatedAt = same
+	}
+
+	// Equal keys compare equal in both directions
+	asc, _ := store.Query(ListOptions{SortBy: SortTitle, Ascending: true})
+	desc, _ := store.Query(ListOptions{SortBy: SortTitle})
+	for i := range asc {
+		if asc[i].Title != "same" || desc[i].Title != "same" {
+			t.Fatalf("Unexpected sessions: %v %v", titles(asc), titles(desc))
+		}
+	}
+	for _, a := range asc {
+		for _, b := range asc {
+			if sortLess(a, b, SortUpdatedAt) {
+				t.Fatal("Expected sessions with equal keys to compare equal")
+			}
+		}
+	}
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	store := NewStore(t.TempDir())
+	sess := store.Create("", "")
+	if !sess.TitlePending {
+		t.Fatal("Expected an untitled session to wait for a title")
+	}
+
+	title, tags, archived := "Named", []string{" ops ", "", "ops", "Ops", "api"}, true
+	updated, err := store.UpdateMetadata(sess.ID, Update{Title: &title, Tags: &tags, Archived: &archived})
+	if err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	if updated.Title != "Named" || updated.TitlePending {
+		t.Errorf("Expected a user title to end the pending state, got %+v", updated)
+	}
+	if !slices.Equal(updated.Tags, []string{"ops", "api"}) {
+		t.Errorf("Expected trimmed unique tags, got %v", updated.Tags)
+	}
+	if !updated.Archived || updated.ArchivedAt == nil {
+		t.Errorf("Expected the session archived with a time, got %+v", updated)
+	}
+
+	archived = false
+	if updated, _ = store.UpdateMetadata(sess.ID, Update{Archived: &archived}); updated.ArchivedAt != nil {
+		t.Error("Expected unarchiving to clear the archive time")
+	}
+	if _, err := store.UpdateMetadata("missing", Update{Title: &title}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
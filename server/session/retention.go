@@ -0,0 +1,236 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Noon-R/Devport/server/events"
+)
+
+// Tool output compaction modes
+const (
+	CompactOffload  = "offload"  // move large outputs to separate files
+	CompactTruncate = "truncate" // cut large outputs and discard the rest
+)
+
+// outputPreviewBytes is how much of an offloaded output stays in the history
+const outputPreviewBytes = 2048
+
+// RetentionPolicy controls background maintenance of stored sessions.
+// Zero durations disable the corresponding step.
+type RetentionPolicy struct {
+	ArchiveAfter  time.Duration // archive sessions untouched for this long
+	DeleteAfter   time.Duration // delete sessions untouched for this long
+	CompactAfter  time.Duration // compact tool outputs of messages older than this
+	MaxToolOutput int           // tool outputs larger than this many bytes are compacted
+	CompactMode   string        // CompactOffload (default) or CompactTruncate
+	UnloadAfter   time.Duration // drop cached histories of sessions idle this long
+
+	// InUse reports whether a session has a live agent or attached clients;
+	// such sessions are left alone. Nil treats every session as unused.
+	InUse func(sessionID string) bool
+}
+
+// CompactResult describes the outcome of compacting one session
+type CompactResult struct {
+	SessionID  string `json:"session_id"`
+	Outputs    int    `json:"outputs"`     // number of tool outputs compacted
+	BytesFreed int64  `json:"bytes_freed"` // bytes removed from the history
+}
+
+// Report summarizes a maintenance run
+type Report struct {
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Archived   []string        `json:"archived"`
+	Deleted    []string        `json:"deleted"`
+	Compacted  []CompactResult `json:"compacted"`
+	Unloaded   int             `json:"unloaded"`
+	Errors     []string        `json:"errors,omitempty"`
+}
+
+// Compact shrinks tool outputs larger than policy.MaxToolOutput in messages
+// older than olderThan. A zero olderThan compacts every message.
+func (s *Store) Compact(sessionID string, policy RetentionPolicy, olderThan time.Time) (CompactResult, error) {
+	result := CompactResult{SessionID: sessionID}
+	if s.Get(sessionID) == nil {
+		return result, ErrNotFound
+	}
+	if policy.MaxToolOutput <= 0 {
+		return result, nil
+	}
+
+	defer s.lock(sessionID)()
+
+	// Compact a copy: readers may still be using the cached history
+	history := slices.Clone(s.loadHistory(sessionID))
+	for i := range history {
+		msg := &history[i]
+		if !olderThan.IsZero() && !msg.Timestamp.Before(olderThan) {
+			continue
+		}
+		cloned := false
+		for j := range msg.ToolCalls {
+			if len(msg.ToolCalls[j].Output) <= policy.MaxToolOutput {
+				continue
+			}
+			if !cloned {
+				msg.ToolCalls = slices.Clone(msg.ToolCalls)
+				cloned = true
+			}
+			tc := &msg.ToolCalls[j]
+
+			size := len(tc.Output)
+			keep := policy.MaxToolOutput
+			if policy.CompactMode != CompactTruncate {
+				ref := fmt.Sprintf("%d-%d.txt", msg.Seq, j)
+				if err := s.writeOutput(sessionID, ref, tc.Output); err != nil {
+					return result, err
+				}
+				tc.OutputRef = ref
+				keep = min(keep, outputPreviewBytes)
+			}
+			// Do not split a multi-byte character
+			for keep > 0 && !utf8.RuneStart(tc.Output[keep]) {
+				keep--
+			}
+			tc.Output = tc.Output[:keep]
+			tc.OutputSize = size
+			tc.OutputTruncated = true
+
+			result.Outputs++
+			result.BytesFreed += int64(size - keep)
+		}
+	}
+
+	if result.Outputs > 0 {
+		s.histories.Store(sessionID, history)
+		if err := s.saveHistoryToDisk(sessionID, history); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// ReadOutput returns a tool output that was offloaded by Compact
+func (s *Store) ReadOutput(sessionID, ref string) ([]byte, error) {
+	// Only known session IDs are used in paths
+	if s.Get(sessionID) == nil || ref == "" || ref != filepath.Base(ref) {
+		return nil, os.ErrNotExist
+	}
+	return s.readFile(filepath.Join(s.sessionsDir, sessionID, "outputs", ref))
+}
+
+func (s *Store) writeOutput(sessionID, ref, output string) error {
+	dir := filepath.Join(s.sessionsDir, sessionID, "outputs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return s.writeFile(filepath.Join(dir, ref), []byte(output))
+}
+
+// Maintain applies the retention policy to every session not in use
+func (s *Store) Maintain(policy RetentionPolicy) Report {
+	now := time.Now()
+	report := Report{
+		StartedAt: now,
+		Archived:  []string{},
+		Deleted:   []string{},
+		Compacted: []CompactResult{},
+	}
+
+	for _, session := range s.List() {
+		if policy.InUse != nil && policy.InUse(session.ID) {
+			continue
+		}
+		idle := now.Sub(session.UpdatedAt)
+
+		// Pinned sessions are exempt from archiving and deletion
+		if !session.Pinned {
+			if policy.DeleteAfter > 0 && idle > policy.DeleteAfter {
+				if err := s.Delete(session.ID); err != nil {
+					report.Errors = append(report.Errors, fmt.Sprintf("delete %s: %v", session.ID, err))
+				} else {
+					report.Deleted = append(report.Deleted, session.ID)
+				}
+				continue
+			}
+			if policy.ArchiveAfter > 0 && idle > policy.ArchiveAfter && !session.Archived {
+				if s.archive(session.ID) {
+					report.Archived = append(report.Archived, session.ID)
+				}
+			}
+		}
+
+		// Only sessions older than CompactAfter can hold messages that old
+		if policy.CompactAfter > 0 && now.Sub(session.CreatedAt) > policy.CompactAfter {
+			result, err := s.Compact(session.ID, policy, now.Add(-policy.CompactAfter))
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("compact %s: %v", session.ID, err))
+			} else if result.Outputs > 0 {
+				report.Compacted = append(report.Compacted, result)
+			}
+		}
+
+		if policy.UnloadAfter > 0 && idle > policy.UnloadAfter {
+			unlock := s.lock(session.ID)
+			_, ok := s.histories.LoadAndDelete(session.ID)
+			unlock()
+			if ok {
+				report.Unloaded++
+				s.emit(events.SessionUnloaded, session)
+			}
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report
+}
+
+// archive marks a session archived without touching UpdatedAt, so that the
+// delete step still counts idle time from the last real activity. It
+// reports false if the session was deleted, pinned or archived meanwhile.
+func (s *Store) archive(sessionID string) bool {
+	defer s.lock(sessionID)()
+	session := s.Get(sessionID)
+	if session == nil || session.Pinned || session.Archived {
+		return false
+	}
+
+	now := time.Now()
+	session.Archived = true
+	session.ArchivedAt = &now
+	s.saveSessionToDisk(session)
+	s.emit(events.SessionUpdated, session)
+	return true
+}
+
+// RunMaintenance applies the retention policy every interval until ctx is
+// done, logging a report of each run that changed anything
+func (s *Store) RunMaintenance(ctx context.Context, policy RetentionPolicy, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report := s.Maintain(policy)
+			if len(report.Archived) > 0 || len(report.Deleted) > 0 || len(report.Compacted) > 0 || len(report.Errors) > 0 {
+				log.Printf("Session maintenance: archived=%d deleted=%d compacted=%d errors=%d",
+					len(report.Archived), len(report.Deleted), len(report.Compacted), len(report.Errors))
+			}
+		}
+	}
+}
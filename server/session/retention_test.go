@@ -0,0 +1,66 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintainSkipsSessionsInUse(t *testing.T) {
+	store := NewStore(t.TempDir())
+	idle := store.Create("Idle", "")
+	busy := store.Create("Busy", "")
+	for _, sess := range []*Session{idle, busy} {
+		sess.UpdatedAt = time.Now().Add(-48 * time.Hour)
+	}
+
+	report := store.Maintain(RetentionPolicy{
+		DeleteAfter: 24 * time.Hour,
+		InUse:       func(sessionID string) bool { return sessionID == busy.ID },
+	})
+
+	if len(report.Deleted) != 1 || report.Deleted[0] != idle.ID {
+		t.Errorf("Expected only the idle session to be deleted, got %v", report.Deleted)
+	}
+	if store.Get(busy.ID) == nil {
+		t.Error("Session in use was deleted")
+	}
+}
+
+func TestReadOutputRejectsUnknownSessions(t *testing.T) {
+	store := NewStore(t.TempDir())
+	for _, id := range []string{"..", "../sessions", "missing"} {
+		if _, err := store.ReadOutput(id, "1-0.txt"); err == nil {
+			t.Errorf("Expected an error for session %q", id)
+		}
+	}
+}
+
+func TestArchiveRechecksUnderLock(t *testing.T) {
+	store := NewStore(t.TempDir())
+	idle := store.Create("Idle", "")
+	pinned := store.Create("Pinned", "")
+	updated := time.Now().Add(-48 * time.Hour)
+	idle.UpdatedAt = updated
+	yes := true
+	if _, err := store.UpdateMetadata(pinned.ID, Update{Pinned: &yes}); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+
+	if !store.archive(idle.ID) {
+		t.Fatal("Expected the idle session to be archived")
+	}
+	if !idle.Archived || idle.ArchivedAt == nil || !idle.UpdatedAt.Equal(updated) {
+		t.Errorf("Expected archiving to keep UpdatedAt, got %+v", idle)
+	}
+
+	// Sessions changed since the maintenance run listed them are skipped
+	if store.archive(idle.ID) {
+		t.Error("Expected an archived session to be skipped")
+	}
+	if store.archive(pinned.ID) {
+		t.Error("Expected a pinned session to be skipped")
+	}
+	if store.archive("missing") {
+		t.Error("Expected a deleted session to be skipped")
+	}
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Noon-R/Devport/server/events"
+	"github.com/google/uuid"
+)
+
+// ErrNotUserMessage is returned when rewinding to a message that was not
+// sent by the user
+var ErrNotUserMessage = errors.New("not a user message")
+
+// Rewind removes the user message messageID and everything after it from
+// the history and returns the removed message. The session is moved to a
+// new agent conversation, seeded with the remaining history on the next
+// message, since the agent cannot forget the removed turns. Sequence
+// numbers are not reused.
+func (s *Store) Rewind(sessionID, messageID string) (HistoryMessage, error) {
+	session := s.Get(sessionID)
+	if session == nil {
+		return HistoryMessage{}, ErrNotFound
+	}
+
+	defer s.lock(sessionID)()
+	history := s.GetHistory(sessionID)
+	idx := indexOf(history, messageID)
+	if idx < 0 {
+		return HistoryMessage{}, ErrMessageNotFound
+	}
+	removed := history[idx]
+	if removed.Role != "user" {
+		return HistoryMessage{}, ErrNotUserMessage
+	}
+
+	kept := make([]HistoryMessage, idx)
+	copy(kept, history[:idx])
+	s.histories.Store(sessionID, kept)
+	s.saveHistoryToDisk(sessionID, kept)
+
+	session.AgentSessionID = uuid.New().String()
+	session.NeedsContext = len(kept) > 0
+	session.UpdatedAt = time.Now()
+	s.saveSessionToDisk(session)
+	s.emit(events.SessionUpdated, session)
+
+	return removed, nil
+}
+
+// LastUserMessage returns the most recent message sent by the user
+func (s *Store) LastUserMessage(sessionID string) (HistoryMessage, bool) {
+	history := s.GetHistory(sessionID)
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == "user" {
+			return history[i], true
+		}
+	}
+	return HistoryMessage{}, false
+}
+
+// AgentSessionID returns the ID of the agent conversation backing a session.
+// It is the session ID unless the session has been rewound.
+func (s *Store) AgentSessionID(sessionID string) string {
+	if session := s.Get(sessionID); session != nil && session.AgentSessionID != "" {
+		return session.AgentSessionID
+	}
+	return sessionID
+}
@@ -2,15 +2,22 @@ package session
 
 import (
 	"encoding/json"
+	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"sync"
 	"time"
 
+	"github.com/Noon-R/Devport/server/crypt"
+	"github.com/Noon-R/Devport/server/events"
 	"github.com/google/uuid"
 )
 
+// DefaultTitle is the title of sessions created without one
+const DefaultTitle = "New Chat"
+
 // Session represents a chat session
 type Session struct {
 	ID        string    `json:"id"`
@@ -18,11 +25,34 @@ type Session struct {
 	WorkDir   string    `json:"work_dir"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// LastSeq is the sequence number of the most recent message
+	LastSeq int64 `json:"last_seq"`
+
+	// User-managed metadata
+	Description string     `json:"description,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Pinned      bool       `json:"pinned"`
+	Archived    bool       `json:"archived"`
+	ArchivedAt  *time.Time `json:"archived_at,omitempty"`
+
+	// Fork origin, set when the session was forked from another one
+	ForkedFrom    string `json:"forked_from,omitempty"`
+	ForkMessageID string `json:"fork_message_id,omitempty"`
+	// TitlePending is set until a title has been generated for a session
+	// created without one
+	TitlePending bool `json:"title_pending,omitempty"`
+	// NeedsContext is set while the agent has not yet been given the history
+	NeedsContext bool `json:"needs_context,omitempty"`
+	// AgentSessionID is the agent conversation in use after a rewind; the
+	// session ID is used until then
+	AgentSessionID string `json:"agent_session_id,omitempty"`
 }
 
 // HistoryMessage represents a message in the session history
 type HistoryMessage struct {
 	ID        string                 `json:"id"`
+	Seq       int64                  `json:"seq"` // monotonically increasing per session
 	Role      string                 `json:"role"` // "user", "assistant", "system"
 	Content   string                 `json:"content"`
 	ToolCalls []ToolCallInfo         `json:"tool_calls,omitempty"`
@@ -36,46 +66,78 @@ type ToolCallInfo struct {
 	Input  map[string]interface{} `json:"input,omitempty"`
 	Output string                 `json:"output,omitempty"`
 	Status string                 `json:"status"` // "pending", "completed", "error"
+
+	// Set when a large output was compacted by the retention policy
+	OutputTruncated bool   `json:"output_truncated,omitempty"`
+	OutputSize      int    `json:"output_size,omitempty"` // original size in bytes
+	OutputRef       string `json:"output_ref,omitempty"`  // offloaded file, see Store.ReadOutput
 }
 
 // Store manages sessions
 type Store struct {
 	sessions    sync.Map
-	histories   sync.Map // map[sessionID][]HistoryMessage
+	histories   sync.Map // map[sessionID][]HistoryMessage, replaced on every change
+	locks       sync.Map // map[sessionID]*sync.Mutex, see lock
 	workDir     string
 	sessionsDir string
+	key         *crypt.Key // nil stores files as plaintext
+	bus         *events.Bus
 }
 
 // NewStore creates a new session store
 func NewStore(workDir string) *Store {
-	sessionsDir := filepath.Join(workDir, ".devport", "sessions")
-	os.MkdirAll(sessionsDir, 0755)
+	return NewStoreWithKey(workDir, nil)
+}
 
-	store := &Store{
-		workDir:     workDir,
-		sessionsDir: sessionsDir,
-	}
+// SetEventBus sets the bus on which session changes are published
+func (s *Store) SetEventBus(bus *events.Bus) {
+	s.bus = bus
+}
 
-	// Load existing sessions from disk
-	store.loadFromDisk()
+// emit publishes a snapshot of a changed session
+func (s *Store) emit(t events.Type, session *Session) {
+	snapshot := *session
+	s.bus.Publish(events.Event{
+		Type:      t,
+		SessionID: session.ID,
+		Data:      &snapshot,
+	})
+}
 
-	return store
+// lock serializes changes to a session, its history and its title flags,
+// and returns the function that releases it. Histories are copy-on-write: writers hold the lock and
+// store a new slice, so readers can use the slice they got without locking.
+func (s *Store) lock(sessionID string) func() {
+	val, _ := s.locks.LoadOrStore(sessionID, &sync.Mutex{})
+	mu := val.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
 }
 
-// Create creates a new session
-func (s *Store) Create(title string) *Session {
+// Create creates a new session working in workDir, or in the store's
+// default directory if workDir is empty. An empty title gets DefaultTitle
+// and is replaced by a generated one after the first exchange.
+func (s *Store) Create(title, workDir string) *Session {
+	if workDir == "" {
+		workDir = s.workDir
+	}
 	session := &Session{
 		ID:        uuid.New().String(),
 		Title:     title,
-		WorkDir:   s.workDir,
+		WorkDir:   workDir,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
+	if title == "" {
+		session.Title = DefaultTitle
+		session.TitlePending = true
+	}
 	s.sessions.Store(session.ID, session)
 	s.histories.Store(session.ID, []HistoryMessage{})
 
 	// Save to disk
 	s.saveSessionToDisk(session)
+	s.emit(events.SessionCreated, session)
 
 	return session
 }
@@ -102,53 +164,98 @@ func (s *Store) List() []*Session {
 	return sessions
 }
 
-// Delete removes a session
-func (s *Store) Delete(id string) {
-	s.sessions.Delete(id)
+// Delete removes a session and its files
+func (s *Store) Delete(id string) error {
+	unlock := s.lock(id)
+	val, ok := s.sessions.LoadAndDelete(id)
+	s.histories.Delete(id)
+	unlock()
+	s.locks.Delete(id)
+	if err := os.RemoveAll(filepath.Join(s.sessionsDir, id)); err != nil {
+		return err
+	}
+	if ok {
+		s.emit(events.SessionDeleted, val.(*Session))
+	}
+	return nil
 }
 
 // UpdateTitle updates the session title
 func (s *Store) UpdateTitle(id, title string) {
+	defer s.lock(id)()
 	if val, ok := s.sessions.Load(id); ok {
 		session := val.(*Session)
 		session.Title = title
+		session.TitlePending = false
 		session.UpdatedAt = time.Now()
 		s.saveSessionToDisk(session)
+		s.emit(events.SessionUpdated, session)
 	}
 }
 
-// AddMessage adds a message to the session history
-func (s *Store) AddMessage(sessionID string, msg HistoryMessage) {
-	val, ok := s.histories.Load(sessionID)
-	if !ok {
-		val = []HistoryMessage{}
+// ClaimTitle reports whether the session still needs a generated title and
+// clears the flag so that only one caller generates it. A caller that ends
+// up without a title hands the claim back with ReleaseTitle.
+func (s *Store) ClaimTitle(id string) bool {
+	defer s.lock(id)()
+	session := s.Get(id)
+	if session == nil || !session.TitlePending {
+		return false
 	}
-	history := val.([]HistoryMessage)
-	history = append(history, msg)
-	s.histories.Store(sessionID, history)
+	session.TitlePending = false
+	s.saveSessionToDisk(session)
+	return true
+}
+
+// ReleaseTitle marks the session as still needing a generated title, after
+// a claim that produced none
+func (s *Store) ReleaseTitle(id string) {
+	defer s.lock(id)()
+	session := s.Get(id)
+	if session == nil || session.TitlePending || session.Title != DefaultTitle {
+		return
+	}
+	session.TitlePending = true
+	s.saveSessionToDisk(session)
+}
 
-	// Update session timestamp
+// AddMessage adds a message to the session history and returns it with
+// its sequence number assigned
+func (s *Store) AddMessage(sessionID string, msg HistoryMessage) HistoryMessage {
+	defer s.lock(sessionID)()
+	history := s.loadHistory(sessionID)
+
+	// Assign the next sequence number and update session timestamp
 	if sessionVal, ok := s.sessions.Load(sessionID); ok {
 		session := sessionVal.(*Session)
+		session.LastSeq++
+		msg.Seq = session.LastSeq
 		session.UpdatedAt = time.Now()
 		s.saveSessionToDisk(session)
+	} else if len(history) > 0 {
+		msg.Seq = history[len(history)-1].Seq + 1
+	} else {
+		msg.Seq = 1
 	}
 
+	history = append(history[:len(history):len(history)], msg)
+	s.histories.Store(sessionID, history)
+
 	// Save history to disk
 	s.saveHistoryToDisk(sessionID, history)
+
+	return msg
 }
 
 // UpdateLastAssistantMessage updates the last assistant message in history
 func (s *Store) UpdateLastAssistantMessage(sessionID string, content string, toolCalls []ToolCallInfo) {
-	val, ok := s.histories.Load(sessionID)
-	if !ok {
-		return
-	}
-	history := val.([]HistoryMessage)
+	defer s.lock(sessionID)()
+	history := s.loadHistory(sessionID)
 
 	// Find last assistant message
 	for i := len(history) - 1; i >= 0; i-- {
 		if history[i].Role == "assistant" {
+			history = slices.Clone(history)
 			history[i].Content = content
 			history[i].ToolCalls = toolCalls
 			s.histories.Store(sessionID, history)
@@ -158,16 +265,66 @@ func (s *Store) UpdateLastAssistantMessage(sessionID string, content string, too
 	}
 }
 
+// UpdateMessage replaces the content and tool calls of the message with
+// msg.ID, keeping its sequence number. It reports whether it was found.
+func (s *Store) UpdateMessage(sessionID string, msg HistoryMessage) bool {
+	defer s.lock(sessionID)()
+	history := s.loadHistory(sessionID)
+
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].ID == msg.ID {
+			history = slices.Clone(history)
+			history[i].Content = msg.Content
+			history[i].ToolCalls = msg.ToolCalls
+			s.histories.Store(sessionID, history)
+			s.saveHistoryToDisk(sessionID, history)
+			return true
+		}
+	}
+	return false
+}
+
 // GetHistory returns the message history for a session
 func (s *Store) GetHistory(sessionID string) []HistoryMessage {
-	val, ok := s.histories.Load(sessionID)
+	return s.loadHistory(sessionID)
+}
+
+// loadHistory returns the cached history of a session, reading it from disk
+// on first access. Unknown sessions have an empty history.
+func (s *Store) loadHistory(sessionID string) []HistoryMessage {
+	if val, ok := s.histories.Load(sessionID); ok {
+		return val.([]HistoryMessage)
+	}
+
+	sessionVal, ok := s.sessions.Load(sessionID)
 	if !ok {
 		return []HistoryMessage{}
 	}
+	session := sessionVal.(*Session)
+
+	history := []HistoryMessage{}
+	historyPath := filepath.Join(s.sessionsDir, sessionID, "history.json")
+	if historyData, err := s.readFile(historyPath); err == nil {
+		if err := json.Unmarshal(historyData, &history); err != nil {
+			history = []HistoryMessage{}
+		}
+	}
+
+	// Histories written before sequence numbers existed are numbered now
+	if renumber(history) {
+		s.saveHistoryToDisk(sessionID, history)
+	}
+	if n := len(history); n > 0 && history[n-1].Seq > session.LastSeq {
+		session.LastSeq = history[n-1].Seq
+		s.saveSessionToDisk(session)
+	}
+
+	val, _ := s.histories.LoadOrStore(sessionID, history)
 	return val.([]HistoryMessage)
 }
 
-// loadFromDisk loads all sessions from disk on startup
+// loadFromDisk loads session metadata from disk on startup. Histories are
+// loaded lazily on first access.
 func (s *Store) loadFromDisk() {
 	entries, err := os.ReadDir(s.sessionsDir)
 	if err != nil {
@@ -184,8 +341,11 @@ func (s *Store) loadFromDisk() {
 
 		// Load metadata
 		metaPath := filepath.Join(sessionDir, "meta.json")
-		metaData, err := os.ReadFile(metaPath)
+		metaData, err := s.readFile(metaPath)
 		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Skipping session %s: %v", sessionID, err)
+			}
 			continue
 		}
 
@@ -194,21 +354,6 @@ func (s *Store) loadFromDisk() {
 			continue
 		}
 		s.sessions.Store(session.ID, &session)
-
-		// Load history
-		historyPath := filepath.Join(sessionDir, "history.json")
-		historyData, err := os.ReadFile(historyPath)
-		if err != nil {
-			s.histories.Store(sessionID, []HistoryMessage{})
-			continue
-		}
-
-		var history []HistoryMessage
-		if err := json.Unmarshal(historyData, &history); err != nil {
-			s.histories.Store(sessionID, []HistoryMessage{})
-			continue
-		}
-		s.histories.Store(sessionID, history)
 	}
 }
 
@@ -224,7 +369,7 @@ func (s *Store) saveSessionToDisk(session *Session) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(metaPath, data, 0644)
+	return s.writeFile(metaPath, data)
 }
 
 // saveHistoryToDisk saves message history to disk
@@ -239,5 +384,5 @@ func (s *Store) saveHistoryToDisk(sessionID string, history []HistoryMessage) er
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(historyPath, data, 0644)
+	return s.writeFile(historyPath, data)
 }
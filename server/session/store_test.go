@@ -0,0 +1,100 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentHistoryChanges(t *testing.T) {
+	store := NewStore(t.TempDir())
+	sess := store.Create("", "")
+
+	const writers, perWriter = 8, 20
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				msg := store.AddMessage(sess.ID, HistoryMessage{
+					ID:   fmt.Sprintf("%d-%d", w, i),
+					Role: "assistant",
+					ToolCalls: []ToolCallInfo{
+						{ID: "tool", Output: strings.Repeat("x", 100)},
+					},
+					Timestamp: time.Now(),
+				})
+				msg.Content = "updated"
+				store.UpdateMessage(sess.ID, msg)
+			}
+		}(w)
+	}
+
+	// Readers and compaction run alongside the writers
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		policy := RetentionPolicy{MaxToolOutput: 10, CompactMode: CompactTruncate}
+		for i := 0; i < 20; i++ {
+			for _, msg := range store.GetHistory(sess.ID) {
+				_ = msg.Content
+				for _, tc := range msg.ToolCalls {
+					_ = tc.Output
+				}
+			}
+			if _, err := store.Compact(sess.ID, policy, time.Time{}); err != nil {
+				t.Errorf("Compact failed: %v", err)
+			}
+		}
+	}()
+
+	wg.Wait()
+	<-done
+
+	history := store.GetHistory(sess.ID)
+	if len(history) != writers*perWriter {
+		t.Fatalf("Expected %d messages, got %d", writers*perWriter, len(history))
+	}
+	for i, msg := range history {
+		if msg.Seq != int64(i+1) {
+			t.Fatalf("Message %d has seq %d", i, msg.Seq)
+		}
+		if msg.Content != "updated" {
+			t.Errorf("Message %s lost its update", msg.ID)
+		}
+	}
+	if got := store.Get(sess.ID).LastSeq; got != writers*perWriter {
+		t.Errorf("Expected LastSeq %d, got %d", writers*perWriter, got)
+	}
+}
+
+func TestCompactDoesNotModifyReadHistory(t *testing.T) {
+	store := NewStore(t.TempDir())
+	sess := store.Create("", "")
+	output := strings.Repeat("x", 100)
+	store.AddMessage(sess.ID, HistoryMessage{
+		ID:        "m1",
+		Role:      "assistant",
+		ToolCalls: []ToolCallInfo{{ID: "tool", Output: output}},
+	})
+
+	before := store.GetHistory(sess.ID)
+	policy := RetentionPolicy{MaxToolOutput: 10, CompactMode: CompactTruncate}
+	result, err := store.Compact(sess.ID, policy, time.Time{})
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if result.Outputs != 1 {
+		t.Fatalf("Expected 1 compacted output, got %d", result.Outputs)
+	}
+
+	if before[0].ToolCalls[0].Output != output {
+		t.Error("Compact modified a history slice held by a reader")
+	}
+	if after := store.GetHistory(sess.ID); len(after[0].ToolCalls[0].Output) != 10 {
+		t.Errorf("Expected a compacted output of 10 bytes, got %d", len(after[0].ToolCalls[0].Output))
+	}
+}
@@ -0,0 +1,48 @@
+package session
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxTitleLength is the maximum length of a heuristic title in runes
+const maxTitleLength = 60
+
+// HeuristicTitle derives a title from the first line of the first user
+// message, or returns an empty string if there is none
+func HeuristicTitle(history []HistoryMessage) string {
+	for _, msg := range history {
+		if msg.Role != "user" {
+			continue
+		}
+		if title := CleanTitle(msg.Content); title != "" {
+			return title
+		}
+	}
+	return ""
+}
+
+// CleanTitle reduces text to a single trimmed line of at most
+// maxTitleLength runes, cutting at a word boundary where possible
+func CleanTitle(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.TrimLeft(text, "#>*-` ")
+	text = strings.Trim(strings.TrimSpace(text), `"'`)
+
+	runes := []rune(text)
+	if len(runes) <= maxTitleLength {
+		return text
+	}
+
+	cut := maxTitleLength
+	for i := maxTitleLength; i > maxTitleLength/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimSpace(string(runes[:cut])) + "…"
+}
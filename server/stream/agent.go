@@ -0,0 +1,59 @@
+package stream
+
+import "github.com/Noon-R/Devport/server/agent"
+
+// Notification converts an agent event into the JSON-RPC notification sent
+// to clients. ok is false for events that are not forwarded.
+func Notification(sessionID string, event *agent.Event) (method string, params map[string]interface{}, ok bool) {
+	params = map[string]interface{}{
+		"session_id": sessionID,
+	}
+
+	switch event.Type {
+	case agent.EventTypeText:
+		method = "chat.text"
+		params["content"] = event.Content
+
+	case agent.EventTypeToolCall:
+		method = "chat.tool_call"
+		params["tool_use_id"] = event.ToolUseID
+		params["tool_name"] = event.ToolName
+		params["input"] = event.ToolInput
+
+	case agent.EventTypeToolResult:
+		method = "chat.tool_result"
+		params["tool_use_id"] = event.ToolUseID
+		params["output"] = event.ToolOutput
+
+	case agent.EventTypePermissionRequest:
+		method = "chat.permission_request"
+		params["permission_id"] = event.PermissionID
+		params["tool_name"] = event.ToolName
+		params["description"] = event.Content
+
+	case agent.EventTypeAskUserQuestion:
+		method = "chat.ask_user_question"
+		params["question_id"] = event.QuestionID
+		params["question"] = event.Question
+		params["options"] = event.Options
+
+	case agent.EventTypeDone:
+		method = "chat.done"
+
+	case agent.EventTypeError:
+		method = "chat.error"
+		params["error"] = event.Error
+
+	case agent.EventTypeSystem:
+		method = "chat.system"
+		params["message"] = event.Content
+
+	case agent.EventTypeInterrupted:
+		method = "chat.interrupted"
+
+	default:
+		return "", nil, false
+	}
+
+	return method, params, true
+}
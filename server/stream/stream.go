@@ -0,0 +1,247 @@
+package stream
+
+import "sync"
+
+// DefaultBufferSize is the number of events kept per session for replay
+const DefaultBufferSize = 1000
+
+// Event is a session notification stamped with its sequence number
+type Event struct {
+	Seq       int64                  `json:"event_seq"`
+	SessionID string                 `json:"session_id"`
+	Method    string                 `json:"method"`
+	Params    map[string]interface{} `json:"params"`
+}
+
+// Subscriber receives the events of a session. It is called in sequence
+// order from one of the goroutines publishing to the session.
+type Subscriber func(Event)
+
+// Hub numbers the events of each session, delivers them to every
+// subscriber of the session and keeps the events of the current turn so
+// that a client that lost its connection can catch up
+type Hub struct {
+	mu       sync.Mutex
+	sessions map[string]*buffer
+	size     int
+	nextID   int64
+}
+
+// buffer is a ring of the most recent events of one session
+type buffer struct {
+	seq         int64
+	events      []Event
+	start       int // index of the oldest event
+	count       int
+	subscribers map[int64]Subscriber
+	pending     []delivery // recorded events not yet delivered
+	delivering  bool       // a publisher is draining pending
+}
+
+// delivery is an event with the subscribers it was recorded for
+type delivery struct {
+	event       Event
+	subscribers []Subscriber
+}
+
+// NewHub creates a hub keeping up to size events per session
+func NewHub(size int) *Hub {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &Hub{
+		sessions: make(map[string]*buffer),
+		size:     size,
+	}
+}
+
+func (h *Hub) buffer(sessionID string) *buffer {
+	b, ok := h.sessions[sessionID]
+	if !ok {
+		b = &buffer{
+			events:      make([]Event, h.size),
+			subscribers: make(map[int64]Subscriber),
+		}
+		h.sessions[sessionID] = b
+	}
+	return b
+}
+
+// Publish assigns the next sequence number to an event, records it and
+// delivers it to the session's subscribers. The number is also set as
+// "event_seq" in params.
+//
+// Events are delivered in sequence order without holding the hub lock:
+// the first publisher drains the session's pending events while later
+// ones only queue theirs.
+func (h *Hub) Publish(sessionID, method string, params map[string]interface{}) Event {
+	event, b, drain := h.record(sessionID, method, params)
+	if drain {
+		h.drain(b)
+	}
+	return event
+}
+
+func (h *Hub) record(sessionID, method string, params map[string]interface{}) (Event, *buffer, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	b := h.buffer(sessionID)
+	b.seq++
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	params["event_seq"] = b.seq
+
+	event := Event{
+		Seq:       b.seq,
+		SessionID: sessionID,
+		Method:    method,
+		Params:    params,
+	}
+	if b.count == len(b.events) {
+		// Full: overwrite the oldest event
+		b.events[b.start] = event
+		b.start = (b.start + 1) % len(b.events)
+	} else {
+		b.events[(b.start+b.count)%len(b.events)] = event
+		b.count++
+	}
+
+	if len(b.subscribers) == 0 {
+		return event, b, false
+	}
+	subscribers := make([]Subscriber, 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	b.pending = append(b.pending, delivery{event: event, subscribers: subscribers})
+	if b.delivering {
+		return event, b, false
+	}
+	b.delivering = true
+	return event, b, true
+}
+
+// drain delivers the pending events of a session until none are left
+func (h *Hub) drain(b *buffer) {
+	for {
+		h.mu.Lock()
+		if len(b.pending) == 0 {
+			b.pending = nil
+			b.delivering = false
+			h.mu.Unlock()
+			return
+		}
+		next := b.pending[0]
+		b.pending = b.pending[1:]
+		h.mu.Unlock()
+
+		for _, fn := range next.subscribers {
+			fn(next.event)
+		}
+	}
+}
+
+// Subscribe delivers every future event of a session to fn until the
+// returned function is called
+func (h *Hub) Subscribe(sessionID string, fn Subscriber) (unsubscribe func()) {
+	_, _, unsubscribe = h.SubscribeFrom(sessionID, -1, fn)
+	return unsubscribe
+}
+
+// SubscribeFrom is like Subscribe but also returns the buffered events after
+// sequence number after, as Since does. No event is both returned and
+// delivered, and none falls between them. A negative after skips the replay.
+func (h *Hub) SubscribeFrom(sessionID string, after int64, fn Subscriber) (events []Event, complete bool, unsubscribe func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if after >= 0 {
+		events, complete = h.since(sessionID, after)
+	}
+
+	b := h.buffer(sessionID)
+	h.nextID++
+	id := h.nextID
+	b.subscribers[id] = fn
+
+	var once sync.Once
+	unsubscribe = func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if b, ok := h.sessions[sessionID]; ok {
+				delete(b.subscribers, id)
+			}
+		})
+	}
+	return events, complete, unsubscribe
+}
+
+// StartTurn drops the buffered events of previous turns. Sequence numbers
+// keep increasing.
+func (h *Hub) StartTurn(sessionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	b := h.buffer(sessionID)
+	b.start = 0
+	b.count = 0
+}
+
+// LastSeq returns the sequence number of the latest event of a session
+func (h *Hub) LastSeq(sessionID string) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if b, ok := h.sessions[sessionID]; ok {
+		return b.seq
+	}
+	return 0
+}
+
+// Since returns the buffered events after sequence number after. complete
+// is false if events after it are no longer buffered, or if after is not a
+// number this hub has issued (e.g. from before a server restart); the
+// client must then reload the history instead.
+func (h *Hub) Since(sessionID string, after int64) (events []Event, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.since(sessionID, after)
+}
+
+func (h *Hub) since(sessionID string, after int64) (events []Event, complete bool) {
+	b, ok := h.sessions[sessionID]
+	if !ok {
+		return nil, after == 0
+	}
+	if after > b.seq {
+		return nil, false
+	}
+
+	oldest := b.seq - int64(b.count) + 1
+	complete = after >= oldest-1
+	for i := 0; i < b.count; i++ {
+		event := b.events[(b.start+i)%len(b.events)]
+		if event.Seq > after {
+			events = append(events, event)
+		}
+	}
+	return events, complete
+}
+
+// Subscribed reports whether the session has subscribers
+func (h *Hub) Subscribed(sessionID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, ok := h.sessions[sessionID]
+	return ok && len(b.subscribers) > 0
+}
+
+// Remove forgets a session
+func (h *Hub) Remove(sessionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sessions, sessionID)
+}
@@ -0,0 +1,145 @@
+package stream
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+func eventSeqs(events []Event) []int64 {
+	result := []int64{}
+	for _, event := range events {
+		result = append(result, event.Seq)
+	}
+	return result
+}
+
+func TestSince(t *testing.T) {
+	hub := NewHub(3)
+	for i := 0; i < 5; i++ {
+		hub.Publish("s1", "chat.text", nil)
+	}
+
+	tests := []struct {
+		name         string
+		sessionID    string
+		after        int64
+		want         []int64
+		wantComplete bool
+	}{
+		{"latest", "s1", 5, []int64{}, true},
+		{"within the buffer", "s1", 3, []int64{4, 5}, true},
+		{"just before the oldest", "s1", 2, []int64{3, 4, 5}, true},
+		{"older than the buffer", "s1", 1, []int64{3, 4, 5}, false},
+		{"from the start", "s1", 0, []int64{3, 4, 5}, false},
+		{"not issued", "s1", 9, []int64{}, false},
+		{"unknown session from the start", "s2", 0, []int64{}, true},
+		{"unknown session", "s2", 4, []int64{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, complete := hub.Since(tt.sessionID, tt.after)
+			if got := eventSeqs(events); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			if complete != tt.wantComplete {
+				t.Errorf("Expected complete %v, got %v", tt.wantComplete, complete)
+			}
+		})
+	}
+}
+
+func TestStartTurn(t *testing.T) {
+	hub := NewHub(10)
+	hub.Publish("s1", "chat.text", nil)
+	hub.Publish("s1", "chat.done", nil)
+	hub.StartTurn("s1")
+
+	event := hub.Publish("s1", "chat.text", map[string]interface{}{"content": "x"})
+	if event.Seq != 3 || event.Params["event_seq"] != int64(3) {
+		t.Errorf("Expected sequence numbers to continue, got %+v", event)
+	}
+	if events, complete := hub.Since("s1", 2); !complete || !slices.Equal(eventSeqs(events), []int64{3}) {
+		t.Errorf("Expected the new turn to replay, got %v %v", eventSeqs(events), complete)
+	}
+	if _, complete := hub.Since("s1", 0); complete {
+		t.Error("Expected events of previous turns to be dropped")
+	}
+}
+
+func TestSubscribeFrom(t *testing.T) {
+	hub := NewHub(3)
+	for i := 0; i < 4; i++ {
+		hub.Publish("s1", "chat.text", nil)
+	}
+
+	var delivered []int64
+	events, complete, unsubscribe := hub.SubscribeFrom("s1", 2, func(event Event) {
+		delivered = append(delivered, event.Seq)
+	})
+	if !complete || !slices.Equal(eventSeqs(events), []int64{3, 4}) {
+		t.Errorf("Expected a complete replay of 3 and 4, got %v %v", eventSeqs(events), complete)
+	}
+	hub.Publish("s1", "chat.text", nil)
+	hub.Publish("s2", "chat.text", nil)
+	if !slices.Equal(delivered, []int64{5}) {
+		t.Errorf("Expected only the live event 5, got %v", delivered)
+	}
+
+	// A sequence number older than the buffer replays what is left
+	events, complete, unsubscribeOld := hub.SubscribeFrom("s1", 0, func(Event) {})
+	defer unsubscribeOld()
+	if complete || !slices.Equal(eventSeqs(events), []int64{3, 4, 5}) {
+		t.Errorf("Expected an incomplete replay of 3 to 5, got %v %v", eventSeqs(events), complete)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	hub.Publish("s1", "chat.text", nil)
+	if len(delivered) != 1 {
+		t.Errorf("Expected no delivery after unsubscribing, got %v", delivered)
+	}
+
+	if events, _, unsubscribe := hub.SubscribeFrom("s1", -1, func(Event) {}); events != nil {
+		t.Errorf("Expected no replay for a negative sequence number, got %v", eventSeqs(events))
+	} else {
+		unsubscribe()
+	}
+}
+
+func TestPublishDeliversInOrder(t *testing.T) {
+	const publishers, perPublisher = 8, 200
+	hub := NewHub(publishers * perPublisher)
+
+	var mu sync.Mutex
+	var delivered []int64
+	unsubscribe := hub.Subscribe("s1", func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, event.Seq)
+	})
+	defer unsubscribe()
+
+	var wg sync.WaitGroup
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perPublisher; j++ {
+				hub.Publish("s1", "chat.text", nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delivered) != publishers*perPublisher {
+		t.Fatalf("Expected %d events, got %d", publishers*perPublisher, len(delivered))
+	}
+	for i, seq := range delivered {
+		if seq != int64(i+1) {
+			t.Fatalf("Expected event %d at position %d, got %d", i+1, i, seq)
+		}
+	}
+}
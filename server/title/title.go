@@ -0,0 +1,81 @@
+package title
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Noon-R/Devport/server/agent"
+	"github.com/Noon-R/Devport/server/session"
+)
+
+// generateTimeout bounds the side request made to the agent backend
+const generateTimeout = 30 * time.Second
+
+// maxExcerpt limits how much of each message is sent to the agent
+const maxExcerpt = 1000
+
+// Assign generates and persists a title for a session that was created
+// without one. It returns the updated session, or nil if the session already
+// had a title. The agent is asked first when it implements agent.Titler;
+// otherwise, or if that fails, the title is derived from the first message.
+func Assign(store *session.Store, sessionID string, ag agent.Agent) *session.Session {
+	if !store.ClaimTitle(sessionID) {
+		return nil
+	}
+
+	history := store.GetHistory(sessionID)
+
+	var title string
+	if titler, ok := ag.(agent.Titler); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), generateTimeout)
+		generated, err := titler.GenerateTitle(ctx, firstExchange(history))
+		cancel()
+		if err != nil {
+			log.Printf("Title generation failed for session %s: %v", sessionID, err)
+		}
+		title = session.CleanTitle(generated)
+	}
+	if title == "" {
+		title = session.HeuristicTitle(history)
+	}
+	if title == "" {
+		// Try again after the next exchange
+		store.ReleaseTitle(sessionID)
+		return nil
+	}
+
+	store.UpdateTitle(sessionID, title)
+	return store.Get(sessionID)
+}
+
+// firstExchange renders the first user message and the reply to it
+func firstExchange(history []session.HistoryMessage) string {
+	var sb strings.Builder
+	seenUser := false
+	for _, msg := range history {
+		if msg.Role == "user" {
+			if seenUser {
+				break
+			}
+			seenUser = true
+		}
+		if msg.Role == "system" || msg.Content == "" {
+			continue
+		}
+		content := msg.Content
+		if len(content) > maxExcerpt {
+			// Do not split a multi-byte character
+			cut := maxExcerpt
+			for cut > 0 && !utf8.RuneStart(content[cut]) {
+				cut--
+			}
+			content = content[:cut] + "..."
+		}
+		fmt.Fprintf(&sb, "%s: %s\n\n", msg.Role, content)
+	}
+	return sb.String()
+}
@@ -0,0 +1,93 @@
+package title
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Noon-R/Devport/server/agent"
+	"github.com/Noon-R/Devport/server/session"
+)
+
+// fakeAgent is an agent whose title generation is scripted
+type fakeAgent struct {
+	agent.Agent
+	title string
+	err   error
+	calls atomic.Int32
+}
+
+func (a *fakeAgent) GenerateTitle(ctx context.Context, transcript string) (string, error) {
+	a.calls.Add(1)
+	return a.title, a.err
+}
+
+func TestAssign(t *testing.T) {
+	tests := []struct {
+		name        string
+		agent       *fakeAgent
+		message     string
+		wantTitle   string
+		wantPending bool
+	}{
+		{"generated", &fakeAgent{title: "Fix the build"}, "help me", "Fix the build", false},
+		{"generation failed", &fakeAgent{err: errors.New("offline")}, "Refactor the parser", "Refactor the parser", false},
+		{"nothing to title", &fakeAgent{err: errors.New("offline")}, "", session.DefaultTitle, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := session.NewStore(t.TempDir())
+			sess := store.Create("", "")
+			if tt.message != "" {
+				store.AddMessage(sess.ID, session.HistoryMessage{ID: "m1", Role: "user", Content: tt.message})
+			}
+
+			Assign(store, sess.ID, tt.agent)
+
+			got := store.Get(sess.ID)
+			if got.Title != tt.wantTitle || got.TitlePending != tt.wantPending {
+				t.Errorf("Got title %q (pending %v), want %q (pending %v)",
+					got.Title, got.TitlePending, tt.wantTitle, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestAssignOnce(t *testing.T) {
+	store := session.NewStore(t.TempDir())
+	sess := store.Create("", "")
+	store.AddMessage(sess.ID, session.HistoryMessage{ID: "m1", Role: "user", Content: "hello"})
+	ag := &fakeAgent{title: "Greeting"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Assign(store, sess.ID, ag)
+		}()
+	}
+	wg.Wait()
+
+	if n := ag.calls.Load(); n != 1 {
+		t.Errorf("Expected 1 title generation, got %d", n)
+	}
+}
+
+func TestFirstExchangeKeepsRunes(t *testing.T) {
+	// Three-byte runes that do not line up with maxExcerpt
+	content := "x" + strings.Repeat("日本語", maxExcerpt)
+	history := []session.HistoryMessage{{Role: "user", Content: content}}
+
+	excerpt := firstExchange(history)
+	if !utf8.ValidString(excerpt) {
+		t.Error("Excerpt splits a multi-byte character")
+	}
+	if len(excerpt) > maxExcerpt+len("user: ...\n\n") {
+		t.Errorf("Excerpt is %d bytes", len(excerpt))
+	}
+}
@@ -0,0 +1,127 @@
+package turn
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Noon-R/Devport/server/agent"
+	"github.com/Noon-R/Devport/server/events"
+	"github.com/Noon-R/Devport/server/process"
+	"github.com/Noon-R/Devport/server/session"
+	"github.com/Noon-R/Devport/server/stream"
+	"github.com/Noon-R/Devport/server/title"
+	"github.com/google/uuid"
+)
+
+// Runner runs the turns of every session. It is the one implementation of
+// the chat flow, shared by the WebSocket and REST APIs: it records the user
+// message, seeds fresh agent conversations with the history, streams the
+// agent's events to attached clients, records the response and assigns a
+// title once the first exchange is done.
+type Runner struct {
+	store     *session.Store
+	processes *process.Manager
+	streams   *stream.Hub // nil publishes nothing
+	bus       *events.Bus
+}
+
+// NewRunner creates a runner. Events of each turn are published on streams
+// and turns are announced on bus; either may be nil.
+func NewRunner(store *session.Store, processes *process.Manager, streams *stream.Hub, bus *events.Bus) *Runner {
+	return &Runner{
+		store:     store,
+		processes: processes,
+		streams:   streams,
+		bus:       bus,
+	}
+}
+
+// Start records message as the user's next message and sends it to the
+// session's agent. The turn runs in the background, outliving ctx and the
+// client that started it; Start returns the recorded message.
+func (r *Runner) Start(ctx context.Context, sessionID, message string) (session.HistoryMessage, error) {
+	// The turn holds its own process reference until it ends
+	ag, err := r.processes.GetOrCreate(ctx, sessionID)
+	if err != nil {
+		return session.HistoryMessage{}, err
+	}
+
+	// Replay the history into a fresh agent conversation (forks, rewinds,
+	// imports)
+	content := message
+	history := r.store.PendingContext(sessionID)
+	if history != nil {
+		content = session.SeedPrompt(history, content)
+	}
+
+	userMsg := r.store.AddMessage(sessionID, session.HistoryMessage{
+		ID:        uuid.New().String(),
+		Role:      "user",
+		Content:   message,
+		Timestamp: time.Now(),
+	})
+
+	// Events of this turn are buffered for clients resuming after a
+	// dropped connection
+	if r.streams != nil {
+		r.streams.StartTurn(sessionID)
+	}
+	r.publish(sessionID, "chat.user_message", map[string]interface{}{
+		"session_id": sessionID,
+		"message":    userMsg,
+	})
+
+	go r.run(context.WithoutCancel(ctx), sessionID, content, history != nil, ag)
+	return userMsg, nil
+}
+
+// run sends content to the agent and records the response. seeded tells
+// whether content carries the session's pending context.
+func (r *Runner) run(ctx context.Context, sessionID, content string, seeded bool, ag agent.Agent) {
+	defer r.processes.Release(sessionID)
+
+	events, err := ag.SendMessage(ctx, content)
+	if err != nil {
+		log.Printf("SendMessage error: %v", err)
+		r.publish(sessionID, "chat.error", map[string]interface{}{
+			"session_id": sessionID,
+			"error":      err.Error(),
+		})
+		return
+	}
+	if seeded {
+		r.store.ContextSent(sessionID)
+	}
+
+	// Record the response regardless of which clients are watching
+	recorder := NewRecorder(r.store, r.bus, sessionID)
+	for event := range events {
+		recorder.Record(&event)
+		if event.Type == agent.EventTypeDone {
+			go r.assignTitle(sessionID)
+		}
+		if method, params, ok := stream.Notification(sessionID, &event); ok {
+			r.publish(sessionID, method, params)
+		}
+	}
+	recorder.Finish()
+}
+
+// assignTitle generates a title for a session created without one
+func (r *Runner) assignTitle(sessionID string) {
+	ag, err := r.processes.GetOrCreate(context.Background(), sessionID)
+	if err != nil {
+		return
+	}
+	defer r.processes.Release(sessionID)
+
+	title.Assign(r.store, sessionID, ag)
+}
+
+// publish sends a session event to attached clients, if any
+func (r *Runner) publish(sessionID, method string, params map[string]interface{}) {
+	if r.streams != nil {
+		r.streams.Publish(sessionID, method, params)
+	}
+}
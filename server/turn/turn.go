@@ -0,0 +1,193 @@
+package turn
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Noon-R/Devport/server/agent"
+	"github.com/Noon-R/Devport/server/events"
+	"github.com/Noon-R/Devport/server/session"
+	"github.com/google/uuid"
+)
+
+// CheckpointInterval is how often a turn in progress is saved to history
+const CheckpointInterval = 2 * time.Second
+
+// Recorder assembles the assistant message of one turn from the agent's
+// events and saves it to the session history. It runs alongside the agent,
+// independently of the clients watching the turn, so the response is kept
+// even if every connection drops. Partial content is checkpointed every
+// CheckpointInterval while the turn is in progress, even when the agent is
+// silent, e.g. while a tool runs.
+type Recorder struct {
+	store     *session.Store
+	bus       *events.Bus
+	sessionID string
+
+	mu        sync.Mutex
+	msgID     string
+	content   strings.Builder
+	toolCalls []session.ToolCallInfo
+
+	saved    bool // the message has been added to the history
+	dirty    bool // there are changes since the last save
+	finished bool
+	stop     chan struct{} // closed when the turn finishes
+}
+
+// NewRecorder creates a recorder for a turn of sessionID and announces the
+// turn on bus
+func NewRecorder(store *session.Store, bus *events.Bus, sessionID string) *Recorder {
+	return newRecorder(store, bus, sessionID, CheckpointInterval)
+}
+
+func newRecorder(store *session.Store, bus *events.Bus, sessionID string, interval time.Duration) *Recorder {
+	r := &Recorder{
+		store:     store,
+		bus:       bus,
+		sessionID: sessionID,
+		msgID:     uuid.New().String(),
+		stop:      make(chan struct{}),
+	}
+	bus.Publish(events.Event{
+		Type:      events.TurnStarted,
+		SessionID: sessionID,
+		Data:      map[string]interface{}{"message_id": r.msgID},
+	})
+	go r.checkpoint(interval)
+	return r
+}
+
+// checkpoint saves the turn in progress every interval
+func (r *Recorder) checkpoint(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			if !r.finished {
+				r.save()
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// MessageID returns the ID of the assistant message being recorded
+func (r *Recorder) MessageID() string {
+	return r.msgID
+}
+
+// Record applies an agent event to the turn
+func (r *Recorder) Record(event *agent.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	switch event.Type {
+	case agent.EventTypeText:
+		r.content.WriteString(event.Content)
+		r.dirty = true
+
+	case agent.EventTypeToolCall:
+		r.toolCalls = append(r.toolCalls, session.ToolCallInfo{
+			ID:     event.ToolUseID,
+			Name:   event.ToolName,
+			Input:  event.ToolInput,
+			Status: "pending",
+		})
+		r.dirty = true
+
+	case agent.EventTypeToolResult:
+		for i := range r.toolCalls {
+			if r.toolCalls[i].ID == event.ToolUseID {
+				r.toolCalls[i].Output = event.ToolOutput
+				r.toolCalls[i].Status = "completed"
+				break
+			}
+		}
+		r.dirty = true
+
+	case agent.EventTypePermissionRequest:
+		r.bus.Publish(events.Event{
+			Type:      events.PermissionRequested,
+			SessionID: r.sessionID,
+			Data: map[string]interface{}{
+				"permission_id": event.PermissionID,
+				"tool_name":     event.ToolName,
+				"description":   event.Content,
+			},
+		})
+
+	case agent.EventTypeSystem:
+		sysMsg := session.HistoryMessage{
+			ID:        uuid.New().String(),
+			Role:      "system",
+			Content:   event.Content,
+			Timestamp: time.Now(),
+		}
+		r.store.AddMessage(r.sessionID, sysMsg)
+
+	case agent.EventTypeDone, agent.EventTypeInterrupted, agent.EventTypeError:
+		r.finish(string(event.Type))
+	}
+}
+
+// Finish saves the turn as it stands. It is called on the final event, and
+// must be called when the agent's event stream ends without one.
+func (r *Recorder) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finish("ended")
+}
+
+// finish saves the turn and announces how it ended: "done", "interrupted",
+// "error", or "ended" if the event stream stopped without a final event.
+// The caller holds r.mu.
+func (r *Recorder) finish(status string) {
+	if r.finished {
+		return
+	}
+	r.finished = true
+	close(r.stop)
+	r.save()
+
+	r.bus.Publish(events.Event{
+		Type:      events.TurnFinished,
+		SessionID: r.sessionID,
+		Data: map[string]interface{}{
+			"message_id": r.msgID,
+			"status":     status,
+		},
+	})
+}
+
+// save writes the message to the history if it changed; the caller holds
+// r.mu
+func (r *Recorder) save() {
+	if !r.dirty {
+		return
+	}
+	r.dirty = false
+
+	msg := session.HistoryMessage{
+		ID:        r.msgID,
+		Role:      "assistant",
+		Content:   r.content.String(),
+		ToolCalls: append([]session.ToolCallInfo(nil), r.toolCalls...),
+		Timestamp: time.Now(),
+	}
+	if r.saved && r.store.UpdateMessage(r.sessionID, msg) {
+		return
+	}
+	r.store.AddMessage(r.sessionID, msg)
+	r.saved = true
+}
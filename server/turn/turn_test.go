@@ -0,0 +1,65 @@
+package turn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Noon-R/Devport/server/agent"
+	"github.com/Noon-R/Devport/server/session"
+)
+
+// assistantMessage returns the content of the session's assistant message
+func assistantMessage(store *session.Store, sessionID string) (string, bool) {
+	for _, msg := range store.GetHistory(sessionID) {
+		if msg.Role == "assistant" {
+			return msg.Content, true
+		}
+	}
+	return "", false
+}
+
+func TestRecorderCheckpointsSilentTurn(t *testing.T) {
+	store := session.NewStore(t.TempDir())
+	sess := store.Create("", "")
+
+	r := newRecorder(store, nil, sess.ID, 10*time.Millisecond)
+	r.Record(&agent.Event{Type: agent.EventTypeText, Content: "partial"})
+
+	// No further events arrive, as while a long tool runs
+	deadline := time.Now().Add(time.Second)
+	for {
+		if content, ok := assistantMessage(store, sess.ID); ok {
+			if content != "partial" {
+				t.Fatalf("Expected checkpointed content %q, got %q", "partial", content)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Turn in progress was not checkpointed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	r.Record(&agent.Event{Type: agent.EventTypeText, Content: " and done"})
+	r.Record(&agent.Event{Type: agent.EventTypeDone})
+
+	history := store.GetHistory(sess.ID)
+	if len(history) != 1 || history[0].Content != "partial and done" {
+		t.Errorf("Expected one complete assistant message, got %+v", history)
+	}
+}
+
+func TestRecorderIgnoresEventsAfterFinish(t *testing.T) {
+	store := session.NewStore(t.TempDir())
+	sess := store.Create("", "")
+
+	r := NewRecorder(store, nil, sess.ID)
+	r.Record(&agent.Event{Type: agent.EventTypeText, Content: "answer"})
+	r.Finish()
+	r.Record(&agent.Event{Type: agent.EventTypeText, Content: " late"})
+	r.Finish()
+
+	if content, _ := assistantMessage(store, sess.ID); content != "answer" {
+		t.Errorf("Expected %q, got %q", "answer", content)
+	}
+}
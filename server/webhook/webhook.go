@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Noon-R/Devport/server/events"
+)
+
+// Forwarder posts server events to webhook URLs
+type Forwarder struct {
+	urls   []string
+	secret string
+	client *http.Client
+}
+
+// New creates a forwarder posting to urls. If secret is set, every request
+// is signed with an HMAC-SHA256 of the body in the X-Devport-Signature
+// header.
+func New(urls []string, secret string) *Forwarder {
+	return &Forwarder{
+		urls:   urls,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Attach subscribes the forwarder to the bus and returns a function that
+// detaches it
+func (f *Forwarder) Attach(bus *events.Bus) (detach func()) {
+	return bus.Subscribe(f.deliver)
+}
+
+func (f *Forwarder) deliver(event events.Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Webhook: marshal %s: %v", event.Type, err)
+		return
+	}
+
+	for _, url := range f.urls {
+		if err := f.post(url, event, body); err != nil {
+			log.Printf("Webhook %s: %v", url, err)
+		}
+	}
+}
+
+func (f *Forwarder) post(url string, event events.Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Devport-Event", string(event.Type))
+	if f.secret != "" {
+		mac := hmac.New(sha256.New, []byte(f.secret))
+		mac.Write(body)
+		req.Header.Set("X-Devport-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: status %d", event.Type, resp.StatusCode)
+	}
+	return nil
+}
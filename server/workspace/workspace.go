@@ -0,0 +1,112 @@
+package workspace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrOutsideRoots is returned when a directory is not under an allowed root
+var ErrOutsideRoots = errors.New("directory is outside the allowed project roots")
+
+// Roots is the allowlist of directories sessions may work in
+type Roots struct {
+	roots []string
+}
+
+// Project is a directory that can be used as a session working directory
+type Project struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// New creates an allowlist from the given root directories
+func New(roots ...string) *Roots {
+	r := &Roots{}
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = resolved
+		}
+		r.roots = append(r.roots, abs)
+	}
+	return r
+}
+
+// Resolve validates dir and returns its absolute path. Relative paths are
+// resolved against the first root. Symlinks are followed before the check so
+// that a link cannot escape the allowlist.
+func (r *Roots) Resolve(dir string) (string, error) {
+	if len(r.roots) == 0 {
+		return "", ErrOutsideRoots
+	}
+
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(r.roots[0], dir)
+	}
+	abs, err := filepath.EvalSymlinks(filepath.Clean(dir))
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", errors.New("not a directory")
+	}
+
+	for _, root := range r.roots {
+		if Contains(root, abs) {
+			return abs, nil
+		}
+	}
+	return "", ErrOutsideRoots
+}
+
+// Projects lists the roots and their immediate, non-hidden subdirectories
+func (r *Roots) Projects() []Project {
+	projects := []Project{}
+	for _, root := range r.roots {
+		projects = append(projects, Project{Name: filepath.Base(root), Path: root})
+
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		var children []Project
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			children = append(children, Project{
+				Name: entry.Name(),
+				Path: filepath.Join(root, entry.Name()),
+			})
+		}
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].Name < children[j].Name
+		})
+		projects = append(projects, children...)
+	}
+	return projects
+}
+
+// Contains reports whether path is root itself or a descendant of it.
+// Both paths must be absolute and clean.
+func Contains(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
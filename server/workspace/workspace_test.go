@@ -0,0 +1,158 @@
+package workspace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		root, path string
+		want       bool
+	}{
+		{"/srv/app", "/srv/app", true},
+		{"/srv/app", "/srv/app/web", true},
+		{"/srv/app", "/srv/app/web/src", true},
+		{"/srv/app", "/srv/app/..data", true},
+		{"/srv/app", "/srv/app2", false},
+		{"/srv/app", "/srv/app2/web", false},
+		{"/srv/app", "/srv", false},
+		{"/srv/app", "/srv/other", false},
+		{"/srv/app", "/", false},
+		{"/", "/srv/app", true},
+	}
+	for _, tt := range tests {
+		if got := Contains(tt.root, tt.path); got != tt.want {
+			t.Errorf("Contains(%q, %q) = %v, want %v", tt.root, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	// base/app is the root; base/app2 shares its prefix, base/outside is
+	// reachable from inside the root only through a symlink
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(base, "app")
+	for _, dir := range []string{"app/web", "app2", "outside"} {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "file.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(base, "outside"), filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "web"), filepath.Join(root, "inside")); err != nil {
+		t.Fatal(err)
+	}
+	roots := New(root)
+
+	tests := []struct {
+		name    string
+		dir     string
+		want    string
+		wantErr error // nil accepts any error
+		ok      bool
+	}{
+		{"root", root, root, nil, true},
+		{"child", filepath.Join(root, "web"), filepath.Join(root, "web"), nil, true},
+		{"relative", "web", filepath.Join(root, "web"), nil, true},
+		{"relative root", ".", root, nil, true},
+		{"traversal back inside", filepath.Join(root, "web", "..", "web"), filepath.Join(root, "web"), nil, true},
+		{"symlink inside", filepath.Join(root, "inside"), filepath.Join(root, "web"), nil, true},
+		{"absolute traversal", filepath.Join(root, "..", "outside"), "", ErrOutsideRoots, false},
+		{"relative traversal", "../outside", "", ErrOutsideRoots, false},
+		{"symlink escape", filepath.Join(root, "escape"), "", ErrOutsideRoots, false},
+		{"shared prefix", filepath.Join(base, "app2"), "", ErrOutsideRoots, false},
+		{"parent", base, "", ErrOutsideRoots, false},
+		{"missing", filepath.Join(root, "missing"), "", nil, false},
+		{"file", filepath.Join(root, "file.txt"), "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := roots.Resolve(tt.dir)
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("Expected an error, got %s", got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveWithoutRoots(t *testing.T) {
+	dir := t.TempDir()
+	for _, roots := range []*Roots{New(), New("")} {
+		if _, err := roots.Resolve(dir); !errors.Is(err, ErrOutsideRoots) {
+			t.Errorf("Expected ErrOutsideRoots without roots, got %v", err)
+		}
+	}
+}
+
+func TestResolveSymlinkedRoot(t *testing.T) {
+	// A root given through a symlink contains the children of its target
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(base, "target")
+	if err := os.MkdirAll(filepath.Join(target, "web"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := New(link).Resolve(filepath.Join(link, "web"))
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if got != filepath.Join(target, "web") {
+		t.Errorf("Expected %s, got %s", filepath.Join(target, "web"), got)
+	}
+}
+
+func TestProjects(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"b", "a", ".git"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(root, "README.md"), nil, 0644)
+
+	roots := New(root)
+	projects := roots.Projects()
+	var names []string
+	for _, p := range projects {
+		names = append(names, p.Name)
+	}
+	want := []string{filepath.Base(roots.roots[0]), "a", "b"}
+	if len(names) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, names)
+			break
+		}
+	}
+}
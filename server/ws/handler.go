@@ -1,59 +1,173 @@
 package ws
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/Noon-R/Devport/server/auth"
 	"github.com/Noon-R/Devport/server/config"
+	"github.com/Noon-R/Devport/server/events"
+	"github.com/Noon-R/Devport/server/msgpack"
 	"github.com/Noon-R/Devport/server/process"
 	"github.com/Noon-R/Devport/server/session"
+	"github.com/Noon-R/Devport/server/stream"
+	"github.com/Noon-R/Devport/server/turn"
+	"github.com/Noon-R/Devport/server/workspace"
 	"github.com/coder/websocket"
-	"github.com/coder/websocket/wsjson"
+	"github.com/google/uuid"
 )
 
 type Handler struct {
 	cfg            *config.Config
 	sessionStore   *session.Store
 	processManager *process.Manager
+	roots          *workspace.Roots
 	conns          sync.Map // map[string]*ConnState
+	streams        *stream.Hub
+	bus            *events.Bus
+	turns          *turn.Runner
+	rpc            *Registry
+	metrics        *Metrics
+	tokens         *auth.Store
 }
 
+// maxBatchSize is the largest number of requests accepted in one batch
+const maxBatchSize = 50
+
+// maxSubscriptions is the largest number of sessions one connection can be
+// attached to
+const maxSubscriptions = 32
+
 type ConnState struct {
-	conn                     *websocket.Conn
-	authenticated            bool
-	sessionID                string
-	mu                       sync.Mutex
-	currentAssistantContent  string
-	currentAssistantTools    []ToolCallState
-	currentAssistantMsgID    string
+	conn          *websocket.Conn
+	out           *outbox
+	cancel        context.CancelFunc // closes the connection
+	closeOnce     sync.Once
+	authenticated bool
+	// Attached sessions and their unsubscribe funcs. Each holds a process
+	// reference. Owned by the read loop.
+	subscriptions map[string]func()
+	scopes        map[string]bool
+	token         *auth.Token // set by auth
+	// Negotiated in auth
+	protocolVersion int
+	clientVersion   string
+	limiter         *rateLimiter
+	// Orders replayed events before live ones in the outbox
+	mu sync.Mutex
 }
 
-type ToolCallState struct {
-	ID     string
-	Name   string
-	Input  map[string]interface{}
-	Output string
-	Status string
+// grant adds scopes to the connection
+func (s *ConnState) grant(scopes ...string) {
+	if s.scopes == nil {
+		s.scopes = make(map[string]bool)
+	}
+	for _, scope := range scopes {
+		s.scopes[scope] = true
+	}
 }
 
 func NewHandler(cfg *config.Config) *Handler {
-	return &Handler{
-		cfg:            cfg,
-		sessionStore:   session.NewStore(cfg.WorkDir),
-		processManager: process.NewManager(cfg.WorkDir, 10*time.Minute),
-	}
+	sessionStore := session.NewStore(cfg.WorkDir)
+	processManager := process.NewManager(cfg.WorkDir, 10*time.Minute)
+	return NewHandlerWithDeps(cfg, sessionStore, processManager)
 }
 
 // NewHandlerWithDeps creates a handler with external dependencies
 func NewHandlerWithDeps(cfg *config.Config, sessionStore *session.Store, processManager *process.Manager) *Handler {
-	return &Handler{
+	// Work dirs are read back from disk, so they are checked on every start
+	roots := workspace.New(cfg.Roots()...)
+	processManager.SetResolver(func(sessionID string) (process.SessionConfig, error) {
+		var workDir string
+		if sess := sessionStore.Get(sessionID); sess != nil && sess.WorkDir != "" {
+			dir, err := roots.Resolve(sess.WorkDir)
+			if err != nil {
+				return process.SessionConfig{}, fmt.Errorf("session work dir: %w", err)
+			}
+			workDir = dir
+		}
+		return process.SessionConfig{
+			WorkDir:        workDir,
+			ConversationID: sessionStore.AgentSessionID(sessionID),
+		}, nil
+	})
+
+	bus := events.NewBus()
+	sessionStore.SetEventBus(bus)
+	processManager.SetEventBus(bus)
+
+	h := &Handler{
 		cfg:            cfg,
 		sessionStore:   sessionStore,
 		processManager: processManager,
+		roots:          roots,
+		streams:        stream.NewHub(stream.DefaultBufferSize),
+		bus:            bus,
+		metrics:        NewMetrics(),
+		tokens:         auth.Static(cfg.AuthToken),
 	}
+	h.turns = turn.NewRunner(sessionStore, processManager, h.streams, bus)
+	h.rpc = h.newRegistry()
+	bus.Subscribe(h.forwardEvent,
+		events.SessionUpdated, events.SessionDeleted,
+		events.ProcessEnded, events.FileChanged)
+	bus.Subscribe(h.metrics.RecordEvent)
+	bus.Subscribe(h.forgetStream, events.SessionDeleted, events.SessionUnloaded)
+	return h
+}
+
+// SetTokenStore sets the store authenticating connections, replacing the
+// single server token
+func (h *Handler) SetTokenStore(tokens *auth.Store) {
+	h.tokens = tokens
+}
+
+// GetEventBus returns the server-wide event bus
+func (h *Handler) GetEventBus() *events.Bus {
+	return h.bus
+}
+
+// forwardEvent sends bus events that clients display to every connection
+func (h *Handler) forwardEvent(event events.Event) {
+	switch event.Type {
+	case events.SessionUpdated:
+		h.Broadcast(string(event.Type), map[string]interface{}{
+			"session": event.Data,
+		})
+	case events.SessionDeleted:
+		h.Broadcast(string(event.Type), map[string]interface{}{
+			"session_id": event.SessionID,
+		})
+	case events.ProcessEnded:
+		h.Broadcast("chat.process_ended", map[string]interface{}{
+			"session_id": event.SessionID,
+		})
+	case events.FileChanged:
+		params := map[string]interface{}{
+			"session_id": event.SessionID,
+		}
+		for k, v := range event.Data.(map[string]interface{}) {
+			params[k] = v
+		}
+		h.Broadcast(string(event.Type), params)
+	}
+}
+
+// forgetStream drops the event buffer of a session that was deleted, or
+// unloaded and not reattached since
+func (h *Handler) forgetStream(event events.Event) {
+	if event.Type == events.SessionUnloaded && h.streams.Subscribed(event.SessionID) {
+		return
+	}
+	h.streams.Remove(event.SessionID)
 }
 
 // GetSessionStore returns the session store
@@ -61,14 +175,38 @@ func (h *Handler) GetSessionStore() *session.Store {
 	return h.sessionStore
 }
 
+// RetentionPolicy returns the session retention policy from the config
+func (h *Handler) RetentionPolicy() session.RetentionPolicy {
+	day := 24 * time.Hour
+	return session.RetentionPolicy{
+		ArchiveAfter:  time.Duration(h.cfg.RetentionArchiveDays) * day,
+		DeleteAfter:   time.Duration(h.cfg.RetentionDeleteDays) * day,
+		CompactAfter:  time.Duration(h.cfg.ToolOutputCompactDays) * day,
+		MaxToolOutput: h.cfg.ToolOutputMaxBytes,
+		CompactMode:   h.cfg.ToolOutputMode,
+		UnloadAfter:   day,
+		InUse:         h.inUse,
+	}
+}
+
+// inUse reports whether a session has a live agent or attached clients
+func (h *Handler) inUse(sessionID string) bool {
+	return h.processManager.InUse(sessionID) || h.streams.Subscribed(sessionID)
+}
+
 // GetProcessManager returns the process manager
 func (h *Handler) GetProcessManager() *process.Manager {
 	return h.processManager
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The guard middleware authenticates a single-use ticket in the URL,
+	// since browsers cannot set headers on WebSocket requests
+	token := auth.TokenFrom(r.Context())
+
 	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"},
+		OriginPatterns:  []string{"*"},
+		CompressionMode: h.cfg.CompressionMode(),
 	})
 	if err != nil {
 		log.Printf("WebSocket accept error: %v", err)
@@ -76,51 +214,210 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close(websocket.StatusNormalClosure, "connection closed")
 
-	ctx := r.Context()
+	// Cancelled when the peer stops answering pings or cannot keep up
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
 	state := &ConnState{
 		conn:          conn,
-		authenticated: false,
+		out:           newOutbox(),
+		cancel:        cancel,
+		authenticated: token != nil,
+		token:         token,
+		limiter:       newRateLimiter(),
 	}
+	if token != nil {
+		state.grant(token.Scopes...)
+	}
+	go h.heartbeat(ctx, cancel, conn)
+	go h.writeLoop(ctx, state)
+
+	connID := uuid.New().String()
+	h.conns.Store(connID, state)
+	defer h.conns.Delete(connID)
 
 	log.Printf("New WebSocket connection established")
 
 	// Message loop
 	for {
-		var req JSONRPCRequest
-		if err := wsjson.Read(ctx, conn, &req); err != nil {
+		msgType, data, err := conn.Read(ctx)
+		if err != nil {
 			if websocket.CloseStatus(err) != -1 {
 				log.Printf("WebSocket closed: %v", websocket.CloseStatus(err))
 			} else {
 				log.Printf("Read error: %v", err)
 			}
-			// Release process reference if attached
-			if state.sessionID != "" {
-				h.processManager.Release(state.sessionID)
-			}
+			h.detachAll(state)
 			return
 		}
 
-		resp := h.handleRequest(ctx, state, &req)
-		if resp != nil {
-			state.mu.Lock()
-			err := wsjson.Write(ctx, conn, resp)
-			state.mu.Unlock()
-			if err != nil {
-				log.Printf("Write error: %v", err)
-				return
+		// Binary frames are MessagePack and get MessagePack responses
+		binary := msgType == websocket.MessageBinary
+		var resp interface{}
+		if binary {
+			if data, err = msgpack.ToJSON(data); err != nil {
+				resp = errorResponse(nil, ErrCodeParseError, "Parse error")
 			}
 		}
+		if resp == nil {
+			resp = h.handleMessage(ctx, state, data)
+		}
+		if resp != nil {
+			state.reply(outMessage{value: resp, binary: binary})
+		}
+	}
+}
+
+// handleMessage handles a single JSON-RPC request or a batch. It returns
+// the response to write, or nil.
+func (h *Handler) handleMessage(ctx context.Context, state *ConnState, data []byte) interface{} {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		var req JSONRPCRequest
+		if err := json.Unmarshal(trimmed, &req); err != nil {
+			return errorResponse(nil, ErrCodeParseError, "Parse error")
+		}
+		if resp := h.handleRequest(ctx, state, &req); resp != nil {
+			return resp
+		}
+		return nil
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(trimmed, &batch); err != nil {
+		return errorResponse(nil, ErrCodeParseError, "Parse error")
+	}
+	if len(batch) == 0 {
+		return errorResponse(nil, ErrCodeInvalidRequest, "Empty batch")
+	}
+	if len(batch) > maxBatchSize {
+		return errorResponse(nil, ErrCodeInvalidRequest, "Batch too large")
+	}
+
+	// Requests of a batch are handled in order
+	responses := make([]*JSONRPCResponse, 0, len(batch))
+	for _, raw := range batch {
+		var req JSONRPCRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			responses = append(responses, errorResponse(nil, ErrCodeInvalidRequest, "Invalid request"))
+			continue
+		}
+		if resp := h.handleRequest(ctx, state, &req); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		return nil
 	}
+	return responses
 }
 
-// SendNotification sends a notification to the client (no id field)
-func (h *Handler) SendNotification(ctx context.Context, state *ConnState, method string, params interface{}) error {
-	notification := &JSONRPCNotification{
-		JSONRPC: "2.0",
-		Method:  method,
-		Params:  params,
+// SendNotification queues a notification to the client (no id field)
+func (h *Handler) SendNotification(state *ConnState, method string, params interface{}) {
+	state.send(notification(method, params))
+}
+
+// GetStreamHub returns the hub distributing session events
+func (h *Handler) GetStreamHub() *stream.Hub {
+	return h.streams
+}
+
+// GetTurnRunner returns the runner of chat turns
+func (h *Handler) GetTurnRunner() *turn.Runner {
+	return h.turns
+}
+
+// GetRoots returns the project roots that session work dirs must lie in
+func (h *Handler) GetRoots() *workspace.Roots {
+	return h.roots
+}
+
+// errTooManySubscriptions is returned when attaching beyond maxSubscriptions
+var errTooManySubscriptions = errors.New("too many attached sessions; detach one first")
+
+// attach subscribes the connection to sessionID, taking a process reference
+// that is held until detach. Attaching again to the same session only
+// restarts the subscription to replay from lastEventSeq.
+func (h *Handler) attach(ctx context.Context, state *ConnState, sessionID string, lastEventSeq int64) (replayed int, complete bool, err error) {
+	if _, ok := state.subscriptions[sessionID]; !ok {
+		if len(state.subscriptions) >= maxSubscriptions {
+			return 0, false, errTooManySubscriptions
+		}
+		if _, err := h.processManager.GetOrCreate(ctx, sessionID); err != nil {
+			return 0, false, err
+		}
 	}
+	replayed, complete = h.subscribe(state, sessionID, lastEventSeq)
+	return replayed, complete, nil
+}
+
+// detach unsubscribes the connection from sessionID and releases its process
+// reference. It reports whether the connection was attached.
+func (h *Handler) detach(state *ConnState, sessionID string) bool {
+	unsubscribe, ok := state.subscriptions[sessionID]
+	if !ok {
+		return false
+	}
+	unsubscribe()
+	delete(state.subscriptions, sessionID)
+	h.processManager.Release(sessionID)
+	return true
+}
+
+// detachAll detaches the connection from every session
+func (h *Handler) detachAll(state *ConnState) {
+	for sessionID := range state.subscriptions {
+		h.detach(state, sessionID)
+	}
+}
+
+// attachedSessions returns the IDs of the sessions the connection is attached to
+func (s *ConnState) attachedSessions() []string {
+	ids := make([]string, 0, len(s.subscriptions))
+	for sessionID := range s.subscriptions {
+		ids = append(ids, sessionID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// subscribe (re)starts delivering the events of sessionID to the connection,
+// replaying the buffered events after lastEventSeq (none if negative).
+// It runs on the connection's read loop, which owns the subscriptions.
+func (h *Handler) subscribe(state *ConnState, sessionID string, lastEventSeq int64) (replayed int, complete bool) {
+	if unsubscribe, ok := state.subscriptions[sessionID]; ok {
+		unsubscribe()
+	}
+	if state.subscriptions == nil {
+		state.subscriptions = make(map[string]func())
+	}
+
+	deliver := func(event stream.Event) {
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		h.SendNotification(state, event.Method, event.Params)
+	}
+
+	// Live events wait for the connection lock until the replay is queued
 	state.mu.Lock()
 	defer state.mu.Unlock()
-	return wsjson.Write(ctx, state.conn, notification)
+
+	events, complete, unsubscribe := h.streams.SubscribeFrom(sessionID, lastEventSeq, deliver)
+	state.subscriptions[sessionID] = unsubscribe
+	for _, event := range events {
+		state.reply(notification(event.Method, event.Params))
+	}
+	return len(events), complete
+}
+
+// Broadcast sends a notification to every authenticated connection
+func (h *Handler) Broadcast(method string, params interface{}) {
+	h.conns.Range(func(key, value interface{}) bool {
+		state := value.(*ConnState)
+		if !state.authenticated {
+			return true
+		}
+		h.SendNotification(state, method, params)
+		return true
+	})
 }
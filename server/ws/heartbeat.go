@@ -0,0 +1,53 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// heartbeat pings the peer every interval and cancels the connection when a
+// pong does not arrive within timeout, so that the read loop returns and the
+// connection's subscriptions and process references are released. Pongs are
+// handled by the concurrent read loop.
+func (h *Handler) heartbeat(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn) {
+	interval := h.cfg.HeartbeatInterval
+	if interval <= 0 {
+		return
+	}
+	timeout := h.cfg.HeartbeatTimeout
+	if timeout <= 0 || timeout > interval {
+		timeout = interval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, pingCancel := context.WithTimeout(ctx, timeout)
+			err := conn.Ping(pingCtx)
+			pingCancel()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Heartbeat failed, closing connection: %v", err)
+				}
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// handlePing answers a client heartbeat. It needs no authentication.
+func (h *Handler) handlePing(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	return successResponse(req.ID, map[string]interface{}{
+		"pong":        true,
+		"server_time": time.Now().UnixMilli(),
+	})
+}
@@ -0,0 +1,128 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noon-R/Devport/server/config"
+	"github.com/coder/websocket"
+)
+
+// connPair returns both ends of a WebSocket connection
+func connPair(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("Accept failed: %v", err)
+			return
+		}
+		accepted <- conn
+		<-done
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(done) })
+
+	client, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { client.CloseNow() })
+	server = <-accepted
+	t.Cleanup(func() { server.CloseNow() })
+	return server, client
+}
+
+// readLoop reads from conn until ctx ends so that pongs are handled
+func readLoop(ctx context.Context, conn *websocket.Conn) {
+	go func() {
+		for {
+			if _, _, err := conn.Read(ctx); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func heartbeatHandler(interval, timeout time.Duration) *Handler {
+	return &Handler{cfg: &config.Config{HeartbeatInterval: interval, HeartbeatTimeout: timeout}}
+}
+
+func TestHeartbeatTimeout(t *testing.T) {
+	server, _ := connPair(t) // the client never reads, so it never answers pings
+	h := heartbeatHandler(20*time.Millisecond, 20*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	readLoop(ctx, server)
+
+	done := make(chan struct{})
+	go func() {
+		h.heartbeat(ctx, cancel, server)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the heartbeat to give up on a silent peer")
+	}
+	if ctx.Err() == nil {
+		t.Error("Expected the connection to be cancelled")
+	}
+}
+
+func TestHeartbeatKeepsAnsweringPeer(t *testing.T) {
+	server, client := connPair(t)
+	client.CloseRead(context.Background())
+	h := heartbeatHandler(10*time.Millisecond, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	readLoop(ctx, server)
+
+	done := make(chan struct{})
+	go func() {
+		h.heartbeat(ctx, cancel, server)
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("Expected the connection to stay open while pongs arrive")
+	}
+
+	// Closing the connection stops the heartbeat
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the heartbeat to stop with the connection")
+	}
+}
+
+func TestHeartbeatDisabled(t *testing.T) {
+	h := heartbeatHandler(0, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		h.heartbeat(ctx, cancel, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a zero interval to disable the heartbeat")
+	}
+	if ctx.Err() != nil {
+		t.Error("Expected a disabled heartbeat not to cancel the connection")
+	}
+}
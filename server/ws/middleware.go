@@ -0,0 +1,201 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/Noon-R/Devport/server/auth"
+	"github.com/Noon-R/Devport/server/events"
+)
+
+// Scopes required by methods, granted by the connection's token
+const (
+	ScopeRead     = auth.ScopeRead
+	ScopeChat     = auth.ScopeChat
+	ScopeFSWrite  = auth.ScopeFSWrite
+	ScopeGitWrite = auth.ScopeGitWrite
+	ScopeAdmin    = auth.ScopeAdmin
+)
+
+// Per-connection rate limit
+const (
+	rateLimitPerSecond = 20
+	rateLimitBurst     = 50
+)
+
+// recoverMiddleware turns a panicking handler into an internal error
+func recoverMiddleware(method *Method, next MethodFunc) MethodFunc {
+	return func(ctx context.Context, state *ConnState, req *JSONRPCRequest) (resp *JSONRPCResponse) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic in %s: %v\n%s", method.Name, r, debug.Stack())
+				resp = errorResponse(req.ID, ErrCodeInternal, "Internal error")
+			}
+		}()
+		return next(ctx, state, req)
+	}
+}
+
+// loggingMiddleware logs failed calls, and every call when verbose is set
+func loggingMiddleware(verbose bool) Middleware {
+	return func(method *Method, next MethodFunc) MethodFunc {
+		return func(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+			start := time.Now()
+			resp := next(ctx, state, req)
+			if resp != nil && resp.Error != nil {
+				log.Printf("RPC %s failed in %v: %d %s", method.Name, time.Since(start), resp.Error.Code, resp.Error.Message)
+			} else if verbose {
+				log.Printf("RPC %s ok in %v", method.Name, time.Since(start))
+			}
+			return resp
+		}
+	}
+}
+
+// authMiddleware rejects calls from connections that are not authenticated
+// or lack the method's scope
+func authMiddleware(method *Method, next MethodFunc) MethodFunc {
+	if method.Scope == "" {
+		return next
+	}
+	return func(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+		if !state.authenticated {
+			return errorResponse(req.ID, ErrCodeUnauthorized, "Not authenticated")
+		}
+		if state.token.Expired(time.Now()) {
+			return errorResponse(req.ID, ErrCodeUnauthorized, "Token expired")
+		}
+		if !state.scopes[method.Scope] {
+			return errorResponse(req.ID, ErrCodeForbidden, fmt.Sprintf("Missing scope: %s", method.Scope))
+		}
+		return next(ctx, state, req)
+	}
+}
+
+// validateMiddleware checks params against the method's schema
+func validateMiddleware(method *Method, next MethodFunc) MethodFunc {
+	return func(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+		if err := validateParams(method, req.Params); err != nil {
+			return errorResponse(req.ID, ErrCodeInvalidParams, err.Error())
+		}
+		return next(ctx, state, req)
+	}
+}
+
+// rateLimitMiddleware limits the request rate of each connection
+func rateLimitMiddleware(method *Method, next MethodFunc) MethodFunc {
+	return func(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+		if !state.limiter.allow() {
+			return errorResponse(req.ID, ErrCodeRateLimited, "Rate limit exceeded")
+		}
+		return next(ctx, state, req)
+	}
+}
+
+// rateLimiter is a token bucket
+type rateLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{tokens: rateLimitBurst, last: time.Now()}
+}
+
+func (l *rateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(rateLimitBurst, l.tokens+now.Sub(l.last).Seconds()*rateLimitPerSecond)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// Metrics counts calls per method and server events per type
+type Metrics struct {
+	mu      sync.Mutex
+	methods map[string]*MethodStats
+	events  map[events.Type]int64
+}
+
+// MethodStats are the counters of one method
+type MethodStats struct {
+	Calls   int64   `json:"calls"`
+	Errors  int64   `json:"errors"`
+	TotalMs float64 `json:"total_ms"`
+	MaxMs   float64 `json:"max_ms"`
+}
+
+// NewMetrics creates empty counters
+func NewMetrics() *Metrics {
+	return &Metrics{
+		methods: make(map[string]*MethodStats),
+		events:  make(map[events.Type]int64),
+	}
+}
+
+// RecordEvent counts a bus event; subscribe it to the server's event bus
+func (m *Metrics) RecordEvent(event events.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[event.Type]++
+}
+
+// Middleware records every call
+func (m *Metrics) Middleware(method *Method, next MethodFunc) MethodFunc {
+	return func(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+		start := time.Now()
+		resp := next(ctx, state, req)
+		ms := float64(time.Since(start).Microseconds()) / 1000
+
+		m.mu.Lock()
+		stats, ok := m.methods[method.Name]
+		if !ok {
+			stats = &MethodStats{}
+			m.methods[method.Name] = stats
+		}
+		stats.Calls++
+		if resp != nil && resp.Error != nil {
+			stats.Errors++
+		}
+		stats.TotalMs += ms
+		stats.MaxMs = max(stats.MaxMs, ms)
+		m.mu.Unlock()
+
+		return resp
+	}
+}
+
+// Events returns a copy of the event counters
+func (m *Metrics) Events() map[events.Type]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[events.Type]int64, len(m.events))
+	for t, n := range m.events {
+		snapshot[t] = n
+	}
+	return snapshot
+}
+
+// Snapshot returns a copy of the method counters
+func (m *Metrics) Snapshot() map[string]MethodStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]MethodStats, len(m.methods))
+	for name, stats := range m.methods {
+		snapshot[name] = *stats
+	}
+	return snapshot
+}
@@ -0,0 +1,212 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Noon-R/Devport/server/msgpack"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// Outbound queue limits
+const (
+	outboxSize   = 256              // queued notifications before a client counts as too slow
+	writeTimeout = 10 * time.Second // longest a single write may block
+)
+
+// outMessage is a response or notification waiting to be written
+type outMessage struct {
+	value     interface{}
+	method    string // notification method, empty for responses
+	sessionID string
+	binary    bool // written as MessagePack
+}
+
+func notification(method string, params interface{}) outMessage {
+	msg := outMessage{
+		value: &JSONRPCNotification{
+			JSONRPC: "2.0",
+			Method:  method,
+			Params:  params,
+		},
+		method: method,
+	}
+	if p, ok := params.(map[string]interface{}); ok {
+		msg.sessionID, _ = p["session_id"].(string)
+	}
+	return msg
+}
+
+// outbox is a connection's outbound queue. Publishers only append to it, so
+// a slow client never blocks the agent or other clients; a single writer
+// goroutine drains it.
+type outbox struct {
+	mu        sync.Mutex
+	queue     []outMessage
+	ready     chan struct{} // signalled when messages are queued
+	coalesced int
+	binary    bool // notifications are written as MessagePack
+}
+
+func newOutbox() *outbox {
+	return &outbox{ready: make(chan struct{}, 1)}
+}
+
+// push queues a message. A text delta is merged into a text delta of the same
+// session still waiting in the queue. With bounded set, it returns false
+// instead of growing the queue beyond outboxSize.
+func (o *outbox) push(msg outMessage, bounded bool) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Responses use the encoding of their request, notifications the one
+	// negotiated in auth
+	if msg.method != "" {
+		msg.binary = o.binary
+	}
+
+	if msg.method == "chat.text" && len(o.queue) > 0 {
+		tail := &o.queue[len(o.queue)-1]
+		if tail.method == msg.method && tail.sessionID == msg.sessionID {
+			if merged, ok := mergeText(tail.value, msg.value); ok {
+				tail.value = merged
+				o.coalesced++
+				return true
+			}
+		}
+	}
+	if bounded && len(o.queue) >= outboxSize {
+		return false
+	}
+
+	o.queue = append(o.queue, msg)
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// setBinary selects MessagePack for the notifications queued from now on
+func (o *outbox) setBinary(binary bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.binary = binary
+}
+
+// take removes every queued message, waiting until there is one
+func (o *outbox) take(ctx context.Context) ([]outMessage, bool) {
+	for {
+		o.mu.Lock()
+		queue := o.queue
+		o.queue = nil
+		o.mu.Unlock()
+		if len(queue) > 0 {
+			return queue, true
+		}
+
+		select {
+		case <-o.ready:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// mergeText combines two chat.text notifications into one carrying both
+// deltas and the later event_seq. The params of the originals are shared
+// with other connections and are not modified.
+func mergeText(first, second interface{}) (interface{}, bool) {
+	a, ok1 := first.(*JSONRPCNotification)
+	b, ok2 := second.(*JSONRPCNotification)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	pa, ok1 := a.Params.(map[string]interface{})
+	pb, ok2 := b.Params.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	ca, ok1 := pa["content"].(string)
+	cb, ok2 := pb["content"].(string)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+
+	params := make(map[string]interface{}, len(pb))
+	for k, v := range pb {
+		params[k] = v
+	}
+	params["content"] = ca + cb
+	return &JSONRPCNotification{
+		JSONRPC: b.JSONRPC,
+		Method:  b.Method,
+		Params:  params,
+	}, true
+}
+
+// send queues a live notification or broadcast. A client whose queue is
+// full is disconnected; it can reconnect and catch up with chat.resume.
+func (s *ConnState) send(msg outMessage) {
+	if !s.out.push(msg, true) {
+		s.disconnectSlow()
+	}
+}
+
+// reply queues a response or replayed events requested by the client, which
+// are never refused
+func (s *ConnState) reply(msg outMessage) {
+	s.out.push(msg, false)
+}
+
+// disconnectSlow closes a connection that cannot keep up
+func (s *ConnState) disconnectSlow() {
+	s.closeOnce.Do(func() {
+		log.Printf("Client too slow, disconnecting (%d text deltas coalesced)", s.out.coalescedCount())
+		go s.conn.Close(websocket.StatusTryAgainLater, "client too slow")
+		s.cancel()
+	})
+}
+
+func (o *outbox) coalescedCount() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.coalesced
+}
+
+// writeLoop is the connection's only writer. A write that does not finish
+// within writeTimeout closes the connection.
+func (h *Handler) writeLoop(ctx context.Context, state *ConnState) {
+	for {
+		queue, ok := state.out.take(ctx)
+		if !ok {
+			return
+		}
+		for _, msg := range queue {
+			writeCtx, cancel := context.WithTimeout(ctx, writeTimeout)
+			err := writeMessage(writeCtx, state.conn, msg)
+			cancel()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Write error: %v", err)
+				}
+				state.cancel()
+				return
+			}
+		}
+	}
+}
+
+func writeMessage(ctx context.Context, conn *websocket.Conn, msg outMessage) error {
+	if !msg.binary {
+		return wsjson.Write(ctx, conn, msg.value)
+	}
+	data, err := msgpack.Marshal(msg.value)
+	if err != nil {
+		return err
+	}
+	return conn.Write(ctx, websocket.MessageBinary, data)
+}
@@ -0,0 +1,179 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func textNotification(sessionID, content string, seq int64) outMessage {
+	return notification("chat.text", map[string]interface{}{
+		"session_id": sessionID,
+		"content":    content,
+		"event_seq":  seq,
+	})
+}
+
+func notificationParams(msg outMessage) map[string]interface{} {
+	return msg.value.(*JSONRPCNotification).Params.(map[string]interface{})
+}
+
+func TestOutboxCoalescesText(t *testing.T) {
+	out := newOutbox()
+	first := textNotification("s1", "Hel", 1)
+	out.push(first, true)
+	out.push(textNotification("s1", "lo", 2), true)
+	out.push(textNotification("s2", "other", 3), true)
+	out.push(notification("chat.done", map[string]interface{}{"session_id": "s2"}), true)
+	out.push(textNotification("s2", "after done", 4), true)
+	out.push(textNotification("s2", "!", 5), true)
+
+	queue, ok := out.take(context.Background())
+	if !ok {
+		t.Fatal("Expected queued messages")
+	}
+	want := []struct {
+		method, content string
+		seq             int64
+	}{
+		{"chat.text", "Hello", 2},
+		{"chat.text", "other", 3},
+		{"chat.done", "", 0},
+		{"chat.text", "after done!", 5},
+	}
+	if len(queue) != len(want) {
+		t.Fatalf("Expected %d messages, got %d", len(want), len(queue))
+	}
+	for i, w := range want {
+		if queue[i].method != w.method {
+			t.Errorf("Message %d: expected %s, got %s", i, w.method, queue[i].method)
+			continue
+		}
+		if w.method != "chat.text" {
+			continue
+		}
+		p := notificationParams(queue[i])
+		if p["content"] != w.content || p["event_seq"] != w.seq {
+			t.Errorf("Message %d: expected %q at %d, got %v", i, w.content, w.seq, p)
+		}
+	}
+	if out.coalescedCount() != 2 {
+		t.Errorf("Expected 2 coalesced deltas, got %d", out.coalescedCount())
+	}
+
+	// The params are shared with other connections
+	if notificationParams(first)["content"] != "Hel" {
+		t.Errorf("Expected the original notification unchanged, got %v", notificationParams(first))
+	}
+}
+
+func TestMergeText(t *testing.T) {
+	text := textNotification("s1", "a", 1).value
+	tests := []struct {
+		name          string
+		first, second interface{}
+		ok            bool
+	}{
+		{"text", text, textNotification("s1", "b", 2).value, true},
+		{"response", &JSONRPCResponse{}, text, false},
+		{"params not a map", &JSONRPCNotification{Params: "x"}, text, false},
+		{"content not a string", notification("chat.text", map[string]interface{}{"content": 1}).value, text, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := mergeText(tt.first, tt.second); ok != tt.ok {
+				t.Errorf("Expected ok %v, got %v", tt.ok, ok)
+			}
+		})
+	}
+}
+
+func TestOutboxBounded(t *testing.T) {
+	out := newOutbox()
+	for i := 0; i < outboxSize; i++ {
+		if !out.push(notification("chat.system", nil), true) {
+			t.Fatalf("Expected message %d to be queued", i)
+		}
+	}
+	if out.push(notification("chat.system", nil), true) {
+		t.Error("Expected a full queue to refuse live messages")
+	}
+
+	// A delta still merges into the queued delta at the tail
+	out = newOutbox()
+	for i := 1; i < outboxSize; i++ {
+		out.push(notification("chat.system", nil), true)
+	}
+	out.push(textNotification("s1", "a", 1), true)
+	if !out.push(textNotification("s1", "b", 2), true) {
+		t.Error("Expected a delta to merge into the tail of a full queue")
+	}
+	if out.push(textNotification("s2", "c", 3), true) {
+		t.Error("Expected a delta of another session to be refused")
+	}
+
+	// Replies are never refused
+	out.push(notification("chat.system", nil), false)
+	queue, _ := out.take(context.Background())
+	if len(queue) != outboxSize+1 {
+		t.Errorf("Expected %d messages, got %d", outboxSize+1, len(queue))
+	}
+}
+
+func TestOutboxEncoding(t *testing.T) {
+	out := newOutbox()
+	out.setBinary(true)
+	out.push(notification("chat.system", nil), true)
+	out.push(outMessage{value: &JSONRPCResponse{}}, false)
+
+	queue, _ := out.take(context.Background())
+	if !queue[0].binary || queue[1].binary {
+		t.Errorf("Expected binary notifications and JSON responses, got %v %v", queue[0].binary, queue[1].binary)
+	}
+}
+
+func TestOutboxTakeWaits(t *testing.T) {
+	out := newOutbox()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := out.take(ctx); ok {
+		t.Error("Expected take on an empty queue to wait until the context ends")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		out.push(notification("chat.system", nil), true)
+	}()
+	if queue, ok := out.take(context.Background()); !ok || len(queue) != 1 {
+		t.Errorf("Expected the pushed message, got %d", len(queue))
+	}
+}
+
+func TestSendDisconnectsSlowClient(t *testing.T) {
+	server, client := connPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	state := &ConnState{conn: server, out: newOutbox(), cancel: cancel}
+
+	for i := 0; i < outboxSize; i++ {
+		state.send(notification("chat.system", nil))
+	}
+	if ctx.Err() != nil {
+		t.Fatal("Expected a full queue to keep the connection")
+	}
+
+	state.send(notification("chat.system", nil))
+	state.send(notification("chat.system", nil))
+	if ctx.Err() == nil {
+		t.Fatal("Expected an overflowing queue to cancel the connection")
+	}
+
+	readCtx, readCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer readCancel()
+	_, _, err := client.Read(readCtx)
+	if status := websocket.CloseStatus(err); status != websocket.StatusTryAgainLater {
+		t.Errorf("Expected close status %v, got %v (%v)", websocket.StatusTryAgainLater, status, err)
+	}
+}
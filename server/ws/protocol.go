@@ -0,0 +1,65 @@
+package ws
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Protocol versions. Version 1 is spoken by clients that do not send a
+// version in auth; every later version only adds to it.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+)
+
+// ServerVersion is the Devport server release, set at build time with
+// -ldflags "-X github.com/Noon-R/Devport/server/ws.ServerVersion=..."
+var ServerVersion = "1.0.0"
+
+// Capabilities are the optional features this server supports. Clients
+// may require some of them in auth.
+var Capabilities = []string{
+	"rpc.batch",
+	"rpc.discover",
+	"chat.resume",
+	"chat.detach",
+	"chat.edit_message",
+	"chat.user_message",
+	"session.fork",
+	"session.metadata",
+	"history.cursor",
+	"events.longpoll",
+	"encoding.msgpack",
+	"auth.tokens",
+	"device.pairing",
+	"auth.session",
+}
+
+// Wire encodings a client can select in auth. MessagePack notifications are
+// sent as binary frames; requests may use either encoding at any time and
+// get a response in the same one.
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+)
+
+// negotiate checks a client's protocol requirements and returns the
+// protocol version to use with it. An error means the client or the server
+// must be upgraded.
+func negotiate(clientVersion, clientMinVersion int, required []string) (int, error) {
+	if clientVersion == 0 {
+		clientVersion = MinProtocolVersion
+	}
+	if clientVersion < MinProtocolVersion {
+		return 0, fmt.Errorf("client protocol version %d is no longer supported, please upgrade the client", clientVersion)
+	}
+	if clientMinVersion > ProtocolVersion {
+		return 0, fmt.Errorf("client requires protocol version %d, please upgrade Devport", clientMinVersion)
+	}
+	for _, feature := range required {
+		if !slices.Contains(Capabilities, feature) {
+			return 0, fmt.Errorf("client requires unsupported feature %q, please upgrade Devport", feature)
+		}
+	}
+	return min(clientVersion, ProtocolVersion), nil
+}
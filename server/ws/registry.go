@@ -0,0 +1,146 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MethodFunc handles a JSON-RPC request
+type MethodFunc func(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse
+
+// Middleware wraps the handler of a method. It is applied once per method
+// when the method is registered.
+type Middleware func(method *Method, next MethodFunc) MethodFunc
+
+// Param describes a named parameter of a method
+type Param struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"` // "string", "integer", "number", "boolean", "object" or "array"
+	Required    bool   `json:"required,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Method is a JSON-RPC method and its metadata
+type Method struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Params      []Param `json:"params"`
+	// Scope is the permission the connection needs; empty for methods
+	// callable before authentication
+	Scope string `json:"scope,omitempty"`
+
+	Handler MethodFunc `json:"-"`
+	wrapped MethodFunc
+}
+
+// Registry dispatches JSON-RPC requests to registered methods through a
+// middleware chain
+type Registry struct {
+	methods    map[string]*Method
+	middleware []Middleware
+}
+
+// NewRegistry creates a registry applying middleware to every method, the
+// first one outermost
+func NewRegistry(middleware ...Middleware) *Registry {
+	return &Registry{
+		methods:    make(map[string]*Method),
+		middleware: middleware,
+	}
+}
+
+// Register adds a method
+func (r *Registry) Register(m Method) {
+	method := &m
+	handler := method.Handler
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](method, handler)
+	}
+	method.wrapped = handler
+	r.methods[method.Name] = method
+}
+
+// Methods returns the registered methods sorted by name
+func (r *Registry) Methods() []*Method {
+	methods := make([]*Method, 0, len(r.methods))
+	for _, m := range r.methods {
+		methods = append(methods, m)
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].Name < methods[j].Name
+	})
+	return methods
+}
+
+// Call dispatches a request
+func (r *Registry) Call(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	if req.JSONRPC != "" && req.JSONRPC != "2.0" {
+		return errorResponse(req.ID, ErrCodeInvalidRequest, "Invalid request")
+	}
+	method, ok := r.methods[req.Method]
+	if !ok {
+		return errorResponse(req.ID, ErrCodeMethodNotFound, "Method not found: "+req.Method)
+	}
+	return method.wrapped(ctx, state, req)
+}
+
+// validateParams checks the request's params against the method's schema.
+// Unknown params are allowed.
+func validateParams(method *Method, raw json.RawMessage) error {
+	if len(method.Params) == 0 {
+		return nil
+	}
+
+	params := map[string]json.RawMessage{}
+	if trimmed := strings.TrimSpace(string(raw)); trimmed != "" && trimmed != "null" {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return fmt.Errorf("params must be an object")
+		}
+	}
+
+	for _, p := range method.Params {
+		value, ok := params[p.Name]
+		if !ok || string(value) == "null" {
+			if p.Required {
+				return fmt.Errorf("missing param: %s", p.Name)
+			}
+			continue
+		}
+		if !hasType(value, p.Type) {
+			return fmt.Errorf("param %s must be of type %s", p.Name, p.Type)
+		}
+	}
+	return nil
+}
+
+func hasType(value json.RawMessage, typ string) bool {
+	var v interface{}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return false
+	}
+	switch typ {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	default:
+		return true
+	}
+}
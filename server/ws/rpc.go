@@ -2,14 +2,13 @@ package ws
 
 import (
 	"context"
-	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"log"
 	"time"
 
-	"github.com/Noon-R/Devport/server/agent"
+	"github.com/Noon-R/Devport/server/auth"
 	"github.com/Noon-R/Devport/server/session"
-	"github.com/google/uuid"
 )
 
 // JSON-RPC 2.0 structures
@@ -39,6 +38,12 @@ type JSONRPCError struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// History page sizes for chat.attach and session.get_history
+const (
+	defaultHistoryPage = 50
+	maxHistoryPage     = 500
+)
+
 // Error codes
 const (
 	ErrCodeParseError      = -32700
@@ -49,84 +54,561 @@ const (
 	ErrCodeAuthFailed      = -32001
 	ErrCodeUnauthorized    = -32002
 	ErrCodeSessionNotFound = -32003
+	ErrCodeForbidden       = -32004
+	ErrCodeRateLimited     = -32005
+	ErrCodeUpgradeRequired = -32006
 )
 
 func (h *Handler) handleRequest(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
-	// Require authentication for all methods except "auth"
-	if req.Method != "auth" && !state.authenticated {
-		return errorResponse(req.ID, ErrCodeUnauthorized, "Not authenticated")
-	}
-
-	switch req.Method {
-	case "auth":
-		return h.handleAuth(ctx, state, req)
-	case "session.list":
-		return h.handleSessionList(ctx, state, req)
-	case "session.create":
-		return h.handleSessionCreate(ctx, state, req)
-	case "chat.attach":
-		return h.handleChatAttach(ctx, state, req)
-	case "chat.message":
-		return h.handleChatMessage(ctx, state, req)
-	case "chat.interrupt":
-		return h.handleChatInterrupt(ctx, state, req)
-	case "chat.permission_response":
-		return h.handlePermissionResponse(ctx, state, req)
-	case "chat.question_response":
-		return h.handleQuestionResponse(ctx, state, req)
-	default:
-		return errorResponse(req.ID, ErrCodeMethodNotFound, "Method not found: "+req.Method)
-	}
+	return h.rpc.Call(ctx, state, req)
+}
+
+// newRegistry registers every JSON-RPC method behind the middleware chain
+func (h *Handler) newRegistry() *Registry {
+	r := NewRegistry(
+		recoverMiddleware,
+		loggingMiddleware(h.cfg.LogLevel == "debug"),
+		h.metrics.Middleware,
+		rateLimitMiddleware,
+		authMiddleware,
+		validateMiddleware,
+	)
+
+	sessionID := Param{Name: "session_id", Type: "string", Required: true}
+
+	r.Register(Method{
+		Name:        "auth",
+		Description: "Authenticate the connection with a token",
+		Params: []Param{
+			{Name: "token", Type: "string", Description: "secret or access token; omit on connections opened with a ticket"},
+			{Name: "protocol_version", Type: "integer", Description: "protocol spoken by the client (1 if omitted)"},
+			{Name: "min_protocol_version", Type: "integer", Description: "oldest server protocol the client accepts"},
+			{Name: "client_version", Type: "string"},
+			{Name: "features", Type: "array", Description: "capabilities the client requires"},
+			{Name: "encoding", Type: "string", Description: "json (default) or msgpack for notifications"},
+		},
+		Handler: h.handleAuth,
+	})
+	r.Register(Method{
+		Name:        "ping",
+		Description: "Check that the connection is alive",
+		Handler:     h.handlePing,
+	})
+	r.Register(Method{
+		Name:        "rpc.discover",
+		Description: "List the available methods and their params",
+		Scope:       ScopeRead,
+		Handler:     h.handleDiscover,
+	})
+	r.Register(Method{
+		Name:        "rpc.stats",
+		Description: "Call counters per method and server event counters",
+		Scope:       ScopeAdmin,
+		Handler:     h.handleStats,
+	})
+
+	r.Register(Method{
+		Name:        "auth.refresh",
+		Description: "Exchange a refresh token for a new access token; no auth needed",
+		Params: []Param{
+			{Name: "refresh_token", Type: "string", Required: true},
+		},
+		Handler: h.handleAuthRefresh,
+	})
+	r.Register(Method{
+		Name:        "auth.ticket",
+		Description: "Issue a single-use ticket for a WebSocket or download URL",
+		Scope:       ScopeRead,
+		Handler:     h.handleAuthTicket,
+	})
+	r.Register(Method{
+		Name:        "token.list",
+		Description: "List the named API tokens",
+		Scope:       ScopeAdmin,
+		Handler:     h.handleTokenList,
+	})
+	r.Register(Method{
+		Name:        "token.create",
+		Description: "Create a named API token; the secret is only returned once",
+		Params: []Param{
+			{Name: "name", Type: "string", Required: true},
+			{Name: "scopes", Type: "array", Required: true, Description: "read, chat, fs-write, git-write or admin"},
+			{Name: "expires_in", Type: "integer", Description: "lifetime in seconds, no expiry if omitted"},
+		},
+		Scope:   ScopeAdmin,
+		Handler: h.handleTokenCreate,
+	})
+	r.Register(Method{
+		Name:        "token.revoke",
+		Description: "Revoke a named API token and close its connections",
+		Params: []Param{
+			{Name: "token_id", Type: "string", Required: true},
+		},
+		Scope:   ScopeAdmin,
+		Handler: h.handleTokenRevoke,
+	})
+
+	r.Register(Method{
+		Name:        "device.pair",
+		Description: "Exchange a pairing code for a device token; no auth needed",
+		Params: []Param{
+			{Name: "code", Type: "string", Required: true},
+			{Name: "device_name", Type: "string"},
+		},
+		Handler: h.handleDevicePair,
+	})
+	r.Register(Method{
+		Name:        "device.pairing_code",
+		Description: "Issue a single-use pairing code",
+		Scope:       ScopeAdmin,
+		Handler:     h.handleDevicePairingCode,
+	})
+	r.Register(Method{
+		Name:        "device.list",
+		Description: "List the paired devices",
+		Scope:       ScopeAdmin,
+		Handler:     h.handleDeviceList,
+	})
+	r.Register(Method{
+		Name:        "device.revoke",
+		Description: "Unpair a device and close its connections",
+		Params: []Param{
+			{Name: "device_id", Type: "string", Required: true},
+		},
+		Scope:   ScopeAdmin,
+		Handler: h.handleDeviceRevoke,
+	})
+
+	r.Register(Method{
+		Name:        "session.list",
+		Description: "List sessions with filters, sorting and pagination",
+		Params: []Param{
+			{Name: "tags", Type: "array", Description: "sessions having all of these tags"},
+			{Name: "pinned", Type: "boolean"},
+			{Name: "archived", Type: "string", Description: "exclude (default), include or only"},
+			{Name: "search", Type: "string", Description: "substring of title or description"},
+			{Name: "sort", Type: "string", Description: "updated_at (default), created_at or title"},
+			{Name: "order", Type: "string", Description: "desc (default) or asc"},
+			{Name: "offset", Type: "integer"},
+			{Name: "limit", Type: "integer"},
+		},
+		Scope:   ScopeRead,
+		Handler: h.handleSessionList,
+	})
+	r.Register(Method{
+		Name:        "session.create",
+		Description: "Create a session",
+		Params: []Param{
+			{Name: "title", Type: "string", Description: "generated after the first exchange if empty"},
+			{Name: "work_dir", Type: "string", Description: "working directory within the project roots"},
+		},
+		Scope:   ScopeChat,
+		Handler: h.handleSessionCreate,
+	})
+	r.Register(Method{
+		Name:        "session.update",
+		Description: "Update session metadata",
+		Params: []Param{
+			sessionID,
+			{Name: "title", Type: "string"},
+			{Name: "description", Type: "string"},
+			{Name: "tags", Type: "array"},
+			{Name: "pinned", Type: "boolean"},
+			{Name: "archived", Type: "boolean"},
+		},
+		Scope:   ScopeChat,
+		Handler: h.handleSessionUpdate,
+	})
+	r.Register(Method{
+		Name:        "session.fork",
+		Description: "Create a new session from the history up to a message",
+		Params: []Param{
+			sessionID,
+			{Name: "message_id", Type: "string", Required: true},
+		},
+		Scope:   ScopeChat,
+		Handler: h.handleSessionFork,
+	})
+	r.Register(Method{
+		Name:        "session.get_history",
+		Description: "Get a page of history by sequence cursors",
+		Params: []Param{
+			sessionID,
+			{Name: "limit", Type: "integer"},
+			{Name: "before", Type: "integer", Description: "messages with a lower seq"},
+			{Name: "after", Type: "integer", Description: "messages with a higher seq"},
+		},
+		Scope:   ScopeRead,
+		Handler: h.handleSessionGetHistory,
+	})
+	r.Register(Method{
+		Name:        "session.compact",
+		Description: "Compact large tool outputs of a session, or run the retention policy",
+		Params: []Param{
+			{Name: "session_id", Type: "string", Description: "run the whole retention policy if empty"},
+		},
+		Scope:   ScopeAdmin,
+		Handler: h.handleSessionCompact,
+	})
+	r.Register(Method{
+		Name:        "project.list",
+		Description: "List directories usable as session working directories",
+		Scope:       ScopeRead,
+		Handler:     h.handleProjectList,
+	})
+
+	r.Register(Method{
+		Name:        "chat.attach",
+		Description: "Subscribe to a session, in addition to those already attached, and get the latest page of its history",
+		Params: []Param{
+			sessionID,
+			{Name: "history_limit", Type: "integer"},
+		},
+		Scope:   ScopeRead,
+		Handler: h.handleChatAttach,
+	})
+	r.Register(Method{
+		Name:        "chat.detach",
+		Description: "Stop receiving the events of a session",
+		Params:      []Param{sessionID},
+		Scope:       ScopeRead,
+		Handler:     h.handleChatDetach,
+	})
+	r.Register(Method{
+		Name:        "chat.resume",
+		Description: "Resubscribe to a session and replay the events after a sequence number",
+		Params: []Param{
+			sessionID,
+			{Name: "last_event_seq", Type: "integer", Required: true},
+		},
+		Scope:   ScopeRead,
+		Handler: h.handleChatResume,
+	})
+	r.Register(Method{
+		Name:        "chat.message",
+		Description: "Send a message to the agent",
+		Params: []Param{
+			sessionID,
+			{Name: "content", Type: "string", Required: true},
+		},
+		Scope:   ScopeChat,
+		Handler: h.handleChatMessage,
+	})
+	r.Register(Method{
+		Name:        "chat.edit_message",
+		Description: "Replace a user message and regenerate the response",
+		Params: []Param{
+			sessionID,
+			{Name: "message_id", Type: "string", Required: true},
+			{Name: "content", Type: "string", Required: true},
+			{Name: "branch", Type: "boolean", Description: "continue in a fork, keeping the original"},
+		},
+		Scope:   ScopeChat,
+		Handler: h.handleChatEditMessage,
+	})
+	r.Register(Method{
+		Name:        "chat.regenerate",
+		Description: "Re-run the last user turn",
+		Params:      []Param{sessionID},
+		Scope:       ScopeChat,
+		Handler:     h.handleChatRegenerate,
+	})
+	r.Register(Method{
+		Name:        "chat.interrupt",
+		Description: "Interrupt the agent",
+		Params:      []Param{sessionID},
+		Scope:       ScopeChat,
+		Handler:     h.handleChatInterrupt,
+	})
+	r.Register(Method{
+		Name:        "chat.permission_response",
+		Description: "Answer a permission request",
+		Params: []Param{
+			sessionID,
+			{Name: "permission_id", Type: "string", Required: true},
+			{Name: "allowed", Type: "boolean", Required: true},
+		},
+		Scope:   ScopeChat,
+		Handler: h.handlePermissionResponse,
+	})
+	r.Register(Method{
+		Name:        "chat.question_response",
+		Description: "Answer a question from the agent",
+		Params: []Param{
+			sessionID,
+			{Name: "question_id", Type: "string", Required: true},
+			{Name: "answer", Type: "string", Required: true},
+		},
+		Scope:   ScopeChat,
+		Handler: h.handleQuestionResponse,
+	})
+
+	return r
+}
+
+// handleDiscover lists the registered methods
+func (h *Handler) handleDiscover(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	return successResponse(req.ID, map[string]interface{}{
+		"methods": h.rpc.Methods(),
+	})
+}
+
+// handleStats returns the call counters of every method and the number of
+// server events of each type
+func (h *Handler) handleStats(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	return successResponse(req.ID, map[string]interface{}{
+		"methods": h.metrics.Snapshot(),
+		"events":  h.metrics.Events(),
+	})
 }
 
-// handleAuth authenticates the client with a token
+// handleAuth authenticates the client with a token and negotiates the
+// protocol version
 func (h *Handler) handleAuth(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
 	var params struct {
-		Token string `json:"token"`
+		Token              string   `json:"token"`
+		ProtocolVersion    int      `json:"protocol_version"`
+		MinProtocolVersion int      `json:"min_protocol_version"`
+		ClientVersion      string   `json:"client_version"`
+		Features           []string `json:"features"`
+		Encoding           string   `json:"encoding"`
 	}
 	if err := json.Unmarshal(req.Params, &params); err != nil {
 		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
 	}
 
-	// Constant-time comparison to prevent timing attacks
-	if subtle.ConstantTimeCompare([]byte(params.Token), []byte(h.cfg.AuthToken)) != 1 {
-		return errorResponse(req.ID, ErrCodeAuthFailed, "Invalid token")
+	// A connection opened with a ticket only negotiates
+	token := state.token
+	if params.Token != "" || token == nil {
+		client := auth.ClientFrom(ctx)
+		if resp := lockedOut(req, client); resp != nil {
+			return resp
+		}
+		var err error
+		if token, err = h.tokens.Authenticate(params.Token); err != nil {
+			client.Fail("ws auth", err)
+			return errorResponse(req.ID, ErrCodeAuthFailed, "Invalid token")
+		}
+		client.Succeed()
+	}
+
+	version, err := negotiate(params.ProtocolVersion, params.MinProtocolVersion, params.Features)
+	if err != nil {
+		resp := errorResponse(req.ID, ErrCodeUpgradeRequired, err.Error())
+		resp.Error.Data = map[string]interface{}{
+			"protocol_version":     ProtocolVersion,
+			"min_protocol_version": MinProtocolVersion,
+			"server_version":       ServerVersion,
+			"capabilities":         Capabilities,
+		}
+		return resp
+	}
+
+	switch params.Encoding {
+	case "":
+		params.Encoding = EncodingJSON
+	case EncodingJSON, EncodingMsgpack:
+	default:
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Unsupported encoding: "+params.Encoding)
+	}
+
+	session, err := h.tokens.Issue(token)
+	if err != nil {
+		return errorResponse(req.ID, ErrCodeInternal, err.Error())
 	}
 
 	state.authenticated = true
-	return successResponse(req.ID, map[string]bool{"success": true})
+	state.token = token
+	state.protocolVersion = version
+	state.clientVersion = params.ClientVersion
+	// Re-authenticating replaces the scopes of the previous token
+	state.scopes = nil
+	state.grant(token.Scopes...)
+	state.out.setBinary(params.Encoding == EncodingMsgpack)
+	log.Printf("Client authenticated as %q (version %q, protocol %d, %s)", token.Name, params.ClientVersion, version, params.Encoding)
+
+	return successResponse(req.ID, map[string]interface{}{
+		"success":          true,
+		"status":           "authenticated",
+		"protocol_version": version,
+		"server_version":   ServerVersion,
+		"capabilities":     Capabilities,
+		"encoding":         params.Encoding,
+		"scopes":           token.Scopes,
+		"access_token":     session.AccessToken,
+		"refresh_token":    session.RefreshToken,
+		"expires_in":       session.ExpiresIn,
+	})
 }
 
-// handleSessionList returns the list of sessions
+// handleSessionList returns the list of sessions matching the given filters
 func (h *Handler) handleSessionList(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
-	sessions := h.sessionStore.List()
+	var params struct {
+		Tags     []string `json:"tags"`
+		Pinned   *bool    `json:"pinned"`
+		Archived string   `json:"archived"` // "exclude", "include" or "only"
+		Search   string   `json:"search"`
+		Sort     string   `json:"sort"`  // "updated_at", "created_at" or "title"
+		Order    string   `json:"order"` // "desc" or "asc"
+		Offset   int      `json:"offset"`
+		Limit    int      `json:"limit"`
+	}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+		}
+	}
+
+	switch params.Sort {
+	case "", session.SortUpdatedAt, session.SortCreatedAt, session.SortTitle:
+	default:
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid sort key: "+params.Sort)
+	}
+	if params.Offset < 0 || params.Limit < 0 {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid pagination")
+	}
+
+	sessions, total := h.sessionStore.Query(session.ListOptions{
+		Tags:      params.Tags,
+		Pinned:    params.Pinned,
+		Archived:  params.Archived,
+		Search:    params.Search,
+		SortBy:    params.Sort,
+		Ascending: params.Order == "asc",
+		Offset:    params.Offset,
+		Limit:     params.Limit,
+	})
 	return successResponse(req.ID, map[string]interface{}{
 		"sessions": sessions,
+		"total":    total,
+		"has_more": params.Offset+len(sessions) < total,
+	})
+}
+
+// handleSessionUpdate updates session metadata (title, description, tags, pinned, archived)
+func (h *Handler) handleSessionUpdate(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		SessionID   string    `json:"session_id"`
+		Title       *string   `json:"title"`
+		Description *string   `json:"description"`
+		Tags        *[]string `json:"tags"`
+		Pinned      *bool     `json:"pinned"`
+		Archived    *bool     `json:"archived"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
+
+	sess, err := h.sessionStore.UpdateMetadata(params.SessionID, session.Update{
+		Title:       params.Title,
+		Description: params.Description,
+		Tags:        params.Tags,
+		Pinned:      params.Pinned,
+		Archived:    params.Archived,
+	})
+	if err != nil {
+		return errorResponse(req.ID, ErrCodeSessionNotFound, "Session not found")
+	}
+
+	return successResponse(req.ID, map[string]interface{}{
+		"session": sess,
 	})
 }
 
 // handleSessionCreate creates a new session
 func (h *Handler) handleSessionCreate(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
 	var params struct {
-		Title string `json:"title"`
+		Title   string `json:"title"`
+		WorkDir string `json:"work_dir"`
 	}
 	json.Unmarshal(req.Params, &params)
 
-	if params.Title == "" {
-		params.Title = "New Chat"
+	// Sessions default to the server's work dir; others must be allowlisted
+	var workDir string
+	if params.WorkDir != "" {
+		dir, err := h.roots.Resolve(params.WorkDir)
+		if err != nil {
+			return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid work_dir: "+err.Error())
+		}
+		workDir = dir
 	}
 
-	session := h.sessionStore.Create(params.Title)
+	// An empty title is replaced with a generated one after the first exchange
+	session := h.sessionStore.Create(params.Title, workDir)
 	return successResponse(req.ID, map[string]interface{}{
 		"session": session,
 	})
 }
 
-// handleChatAttach attaches to a session
-func (h *Handler) handleChatAttach(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+// handleSessionCompact compacts large tool outputs of one session, or runs
+// the full retention maintenance when no session is given
+func (h *Handler) handleSessionCompact(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
 	var params struct {
 		SessionID string `json:"session_id"`
 	}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+		}
+	}
+
+	policy := h.RetentionPolicy()
+	if params.SessionID == "" {
+		report := h.sessionStore.Maintain(policy)
+		return successResponse(req.ID, map[string]interface{}{
+			"report": report,
+		})
+	}
+
+	result, err := h.sessionStore.Compact(params.SessionID, policy, time.Time{})
+	if errors.Is(err, session.ErrNotFound) {
+		return errorResponse(req.ID, ErrCodeSessionNotFound, "Session not found")
+	}
+	if err != nil {
+		return errorResponse(req.ID, ErrCodeInternal, err.Error())
+	}
+
+	return successResponse(req.ID, map[string]interface{}{
+		"result": result,
+	})
+}
+
+// handleProjectList returns the directories sessions can be created in
+func (h *Handler) handleProjectList(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	return successResponse(req.ID, map[string]interface{}{
+		"projects": h.roots.Projects(),
+	})
+}
+
+// handleSessionFork creates a new session branching off at the given message
+func (h *Handler) handleSessionFork(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		SessionID string `json:"session_id"`
+		MessageID string `json:"message_id"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
+
+	forked, err := h.sessionStore.Fork(params.SessionID, params.MessageID)
+	if errors.Is(err, session.ErrNotFound) {
+		return errorResponse(req.ID, ErrCodeSessionNotFound, "Session not found")
+	}
+	if err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, err.Error())
+	}
+
+	return successResponse(req.ID, map[string]interface{}{
+		"session": forked,
+	})
+}
+
+// handleChatAttach attaches to a session and returns the latest page of its history
+func (h *Handler) handleChatAttach(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		SessionID    string `json:"session_id"`
+		HistoryLimit int    `json:"history_limit"`
+	}
 	if err := json.Unmarshal(req.Params, &params); err != nil {
 		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
 	}
@@ -137,21 +619,110 @@ func (h *Handler) handleChatAttach(ctx context.Context, state *ConnState, req *J
 		return errorResponse(req.ID, ErrCodeSessionNotFound, "Session not found")
 	}
 
-	// Get or create agent for this session
-	_, err := h.processManager.GetOrCreate(ctx, params.SessionID)
+	if _, _, err := h.attach(ctx, state, params.SessionID, -1); err != nil {
+		return attachError(req.ID, err)
+	}
+
+	// Get the latest page of history; older pages via session.get_history
+	page := h.sessionStore.GetHistoryPage(params.SessionID, session.PageOptions{
+		Limit: historyLimit(params.HistoryLimit),
+	})
+
+	return successResponse(req.ID, map[string]interface{}{
+		"session_id": params.SessionID,
+		"status":     "attached",
+		"history":    page.Messages,
+		"has_more":   page.HasMore,
+		"last_seq":   page.LastSeq,
+		"event_seq":  h.streams.LastSeq(params.SessionID),
+		"attached":   state.attachedSessions(),
+	})
+}
+
+// handleChatDetach stops delivering a session's events to the connection
+func (h *Handler) handleChatDetach(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
+
+	if !h.detach(state, params.SessionID) {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Not attached to session")
+	}
+
+	return successResponse(req.ID, map[string]interface{}{
+		"session_id": params.SessionID,
+		"status":     "detached",
+		"attached":   state.attachedSessions(),
+	})
+}
+
+func attachError(id interface{}, err error) *JSONRPCResponse {
+	if errors.Is(err, errTooManySubscriptions) {
+		return errorResponse(id, ErrCodeInvalidParams, err.Error())
+	}
+	return errorResponse(id, ErrCodeInternal, err.Error())
+}
+
+// handleChatResume reattaches to a session after a dropped connection and
+// replays the events the client missed. If they are no longer buffered the
+// result has complete=false and the client must reload the history.
+func (h *Handler) handleChatResume(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		SessionID    string `json:"session_id"`
+		LastEventSeq int64  `json:"last_event_seq"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
+
+	if h.sessionStore.Get(params.SessionID) == nil {
+		return errorResponse(req.ID, ErrCodeSessionNotFound, "Session not found")
+	}
+
+	replayed, complete, err := h.attach(ctx, state, params.SessionID, params.LastEventSeq)
 	if err != nil {
-		return errorResponse(req.ID, ErrCodeInternal, err.Error())
+		return attachError(req.ID, err)
 	}
 
-	state.sessionID = params.SessionID
+	return successResponse(req.ID, map[string]interface{}{
+		"session_id": params.SessionID,
+		"replayed":   replayed,
+		"complete":   complete,
+		"event_seq":  h.streams.LastSeq(params.SessionID),
+		"attached":   state.attachedSessions(),
+	})
+}
 
-	// Get history for this session
-	history := h.sessionStore.GetHistory(params.SessionID)
+// handleSessionGetHistory returns a page of history selected by sequence cursors
+func (h *Handler) handleSessionGetHistory(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		SessionID string `json:"session_id"`
+		Limit     int    `json:"limit"`
+		Before    int64  `json:"before"`
+		After     int64  `json:"after"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
+
+	if h.sessionStore.Get(params.SessionID) == nil {
+		return errorResponse(req.ID, ErrCodeSessionNotFound, "Session not found")
+	}
+
+	page := h.sessionStore.GetHistoryPage(params.SessionID, session.PageOptions{
+		After:  params.After,
+		Before: params.Before,
+		Limit:  historyLimit(params.Limit),
+	})
 
 	return successResponse(req.ID, map[string]interface{}{
 		"session_id": params.SessionID,
-		"status":     "attached",
-		"history":    history,
+		"messages":   page.Messages,
+		"has_more":   page.HasMore,
+		"last_seq":   page.LastSeq,
 	})
 }
 
@@ -165,46 +736,121 @@ func (h *Handler) handleChatMessage(ctx context.Context, state *ConnState, req *
 		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
 	}
 
-	// Get agent for this session
-	ag, err := h.processManager.GetOrCreate(ctx, params.SessionID)
-	if err != nil {
+	if err := h.sendChat(ctx, state, params.SessionID, params.Content); err != nil {
 		return errorResponse(req.ID, ErrCodeInternal, err.Error())
 	}
 
-	// Save user message to history
-	userMsg := session.HistoryMessage{
-		ID:        uuid.New().String(),
-		Role:      "user",
-		Content:   params.Content,
-		Timestamp: time.Now(),
-	}
-	h.sessionStore.AddMessage(params.SessionID, userMsg)
+	return successResponse(req.ID, map[string]bool{"accepted": true})
+}
 
-	// Initialize assistant message tracking
-	state.mu.Lock()
-	state.currentAssistantMsgID = uuid.New().String()
-	state.currentAssistantContent = ""
-	state.currentAssistantTools = nil
-	state.mu.Unlock()
+// handleChatEditMessage replaces a user message and regenerates the response.
+// The history after the message is discarded, or kept in the original
+// session if branch is set and the edit continues in a fork.
+func (h *Handler) handleChatEditMessage(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		SessionID string `json:"session_id"`
+		MessageID string `json:"message_id"`
+		Content   string `json:"content"`
+		Branch    bool   `json:"branch"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.Content == "" {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
 
-	// Send message and stream events
-	go func() {
-		events, err := ag.SendMessage(ctx, params.Content)
-		if err != nil {
-			log.Printf("SendMessage error: %v", err)
-			h.SendNotification(ctx, state, "chat.error", map[string]interface{}{
-				"session_id": params.SessionID,
-				"error":      err.Error(),
-			})
-			return
+	if params.Branch {
+		forked, err := h.sessionStore.ForkBefore(params.SessionID, params.MessageID)
+		if resp := rewindError(req.ID, err); resp != nil {
+			return resp
 		}
+		if err := h.sendChat(ctx, state, forked.ID, params.Content); err != nil {
+			return errorResponse(req.ID, ErrCodeInternal, err.Error())
+		}
+		return successResponse(req.ID, map[string]interface{}{
+			"accepted": true,
+			"session":  forked,
+		})
+	}
+
+	if resp := h.rewind(req.ID, params.SessionID, params.MessageID); resp != nil {
+		return resp
+	}
+	if err := h.sendChat(ctx, state, params.SessionID, params.Content); err != nil {
+		return errorResponse(req.ID, ErrCodeInternal, err.Error())
+	}
+
+	return successResponse(req.ID, map[string]interface{}{
+		"accepted": true,
+		"session":  h.sessionStore.Get(params.SessionID),
+	})
+}
+
+// handleChatRegenerate discards the last response and re-runs the last user turn
+func (h *Handler) handleChatRegenerate(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
 
-		for event := range events {
-			h.sendEventNotification(ctx, state, params.SessionID, &event)
+	if h.sessionStore.Get(params.SessionID) == nil {
+		return errorResponse(req.ID, ErrCodeSessionNotFound, "Session not found")
+	}
+	last, ok := h.sessionStore.LastUserMessage(params.SessionID)
+	if !ok {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "No user message to regenerate")
+	}
+
+	if resp := h.rewind(req.ID, params.SessionID, last.ID); resp != nil {
+		return resp
+	}
+	if err := h.sendChat(ctx, state, params.SessionID, last.Content); err != nil {
+		return errorResponse(req.ID, ErrCodeInternal, err.Error())
+	}
+
+	return successResponse(req.ID, map[string]interface{}{
+		"accepted": true,
+		"session":  h.sessionStore.Get(params.SessionID),
+	})
+}
+
+// rewind truncates the history at a user message and restarts the agent on a
+// fresh conversation. It refuses while the agent is still responding.
+func (h *Handler) rewind(id interface{}, sessionID, messageID string) *JSONRPCResponse {
+	if h.processManager.IsRunning(sessionID) {
+		return errorResponse(id, ErrCodeInvalidParams, "Session is busy; interrupt it first")
+	}
+
+	_, err := h.sessionStore.Rewind(sessionID, messageID)
+	if resp := rewindError(id, err); resp != nil {
+		return resp
+	}
+	h.processManager.Restart(sessionID)
+	return nil
+}
+
+func rewindError(id interface{}, err error) *JSONRPCResponse {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, session.ErrNotFound):
+		return errorResponse(id, ErrCodeSessionNotFound, "Session not found")
+	default:
+		return errorResponse(id, ErrCodeInvalidParams, err.Error())
+	}
+}
+
+// sendChat starts a turn with a user message, attaching the connection to
+// the session first if needed so that it receives the response
+func (h *Handler) sendChat(ctx context.Context, state *ConnState, sessionID, message string) error {
+	if _, ok := state.subscriptions[sessionID]; !ok {
+		if _, _, err := h.attach(ctx, state, sessionID, -1); err != nil {
+			return err
 		}
-	}()
+	}
 
-	return successResponse(req.ID, map[string]bool{"accepted": true})
+	_, err := h.turns.Start(ctx, sessionID, message)
+	return err
 }
 
 // handleChatInterrupt handles interrupt request
@@ -218,6 +864,7 @@ func (h *Handler) handleChatInterrupt(ctx context.Context, state *ConnState, req
 	if err != nil {
 		return errorResponse(req.ID, ErrCodeInternal, err.Error())
 	}
+	defer h.processManager.Release(params.SessionID)
 
 	if err := ag.Interrupt(ctx); err != nil {
 		return errorResponse(req.ID, ErrCodeInternal, err.Error())
@@ -241,6 +888,7 @@ func (h *Handler) handlePermissionResponse(ctx context.Context, state *ConnState
 	if err != nil {
 		return errorResponse(req.ID, ErrCodeInternal, err.Error())
 	}
+	defer h.processManager.Release(params.SessionID)
 
 	if err := ag.RespondToPermission(ctx, params.PermissionID, params.Allowed); err != nil {
 		return errorResponse(req.ID, ErrCodeInternal, err.Error())
@@ -264,6 +912,7 @@ func (h *Handler) handleQuestionResponse(ctx context.Context, state *ConnState,
 	if err != nil {
 		return errorResponse(req.ID, ErrCodeInternal, err.Error())
 	}
+	defer h.processManager.Release(params.SessionID)
 
 	if err := ag.RespondToQuestion(ctx, params.QuestionID, params.Answer); err != nil {
 		return errorResponse(req.ID, ErrCodeInternal, err.Error())
@@ -272,145 +921,12 @@ func (h *Handler) handleQuestionResponse(ctx context.Context, state *ConnState,
 	return successResponse(req.ID, map[string]bool{"success": true})
 }
 
-// sendEventNotification sends an event as a JSON-RPC notification
-func (h *Handler) sendEventNotification(ctx context.Context, state *ConnState, sessionID string, event *agent.Event) {
-	var method string
-	params := map[string]interface{}{
-		"session_id": sessionID,
-	}
-
-	switch event.Type {
-	case agent.EventTypeText:
-		method = "chat.text"
-		params["content"] = event.Content
-		// Track assistant content
-		state.mu.Lock()
-		state.currentAssistantContent += event.Content
-		state.mu.Unlock()
-
-	case agent.EventTypeToolCall:
-		method = "chat.tool_call"
-		params["tool_use_id"] = event.ToolUseID
-		params["tool_name"] = event.ToolName
-		params["input"] = event.ToolInput
-		// Track tool call
-		state.mu.Lock()
-		state.currentAssistantTools = append(state.currentAssistantTools, ToolCallState{
-			ID:     event.ToolUseID,
-			Name:   event.ToolName,
-			Input:  event.ToolInput,
-			Status: "pending",
-		})
-		state.mu.Unlock()
-
-	case agent.EventTypeToolResult:
-		method = "chat.tool_result"
-		params["tool_use_id"] = event.ToolUseID
-		params["output"] = event.ToolOutput
-		// Update tool call status
-		state.mu.Lock()
-		for i := range state.currentAssistantTools {
-			if state.currentAssistantTools[i].ID == event.ToolUseID {
-				state.currentAssistantTools[i].Output = event.ToolOutput
-				state.currentAssistantTools[i].Status = "completed"
-				break
-			}
-		}
-		state.mu.Unlock()
-
-	case agent.EventTypePermissionRequest:
-		method = "chat.permission_request"
-		params["permission_id"] = event.PermissionID
-		params["tool_name"] = event.ToolName
-		params["description"] = event.Content
-
-	case agent.EventTypeAskUserQuestion:
-		method = "chat.ask_user_question"
-		params["question_id"] = event.QuestionID
-		params["question"] = event.Question
-		params["options"] = event.Options
-
-	case agent.EventTypeDone:
-		method = "chat.done"
-		// Save assistant message to history
-		state.mu.Lock()
-		if state.currentAssistantMsgID != "" {
-			toolCalls := make([]session.ToolCallInfo, len(state.currentAssistantTools))
-			for i, tc := range state.currentAssistantTools {
-				toolCalls[i] = session.ToolCallInfo{
-					ID:     tc.ID,
-					Name:   tc.Name,
-					Input:  tc.Input,
-					Output: tc.Output,
-					Status: tc.Status,
-				}
-			}
-			assistantMsg := session.HistoryMessage{
-				ID:        state.currentAssistantMsgID,
-				Role:      "assistant",
-				Content:   state.currentAssistantContent,
-				ToolCalls: toolCalls,
-				Timestamp: time.Now(),
-			}
-			h.sessionStore.AddMessage(sessionID, assistantMsg)
-			// Reset tracking
-			state.currentAssistantMsgID = ""
-			state.currentAssistantContent = ""
-			state.currentAssistantTools = nil
-		}
-		state.mu.Unlock()
-
-	case agent.EventTypeError:
-		method = "chat.error"
-		params["error"] = event.Error
-
-	case agent.EventTypeSystem:
-		method = "chat.system"
-		params["message"] = event.Content
-		// Save system message to history
-		sysMsg := session.HistoryMessage{
-			ID:        uuid.New().String(),
-			Role:      "system",
-			Content:   event.Content,
-			Timestamp: time.Now(),
-		}
-		h.sessionStore.AddMessage(sessionID, sysMsg)
-
-	case agent.EventTypeInterrupted:
-		method = "chat.interrupted"
-		// Save partial assistant message if any
-		state.mu.Lock()
-		if state.currentAssistantMsgID != "" && (state.currentAssistantContent != "" || len(state.currentAssistantTools) > 0) {
-			toolCalls := make([]session.ToolCallInfo, len(state.currentAssistantTools))
-			for i, tc := range state.currentAssistantTools {
-				toolCalls[i] = session.ToolCallInfo{
-					ID:     tc.ID,
-					Name:   tc.Name,
-					Input:  tc.Input,
-					Output: tc.Output,
-					Status: tc.Status,
-				}
-			}
-			assistantMsg := session.HistoryMessage{
-				ID:        state.currentAssistantMsgID,
-				Role:      "assistant",
-				Content:   state.currentAssistantContent,
-				ToolCalls: toolCalls,
-				Timestamp: time.Now(),
-			}
-			h.sessionStore.AddMessage(sessionID, assistantMsg)
-		}
-		// Reset tracking
-		state.currentAssistantMsgID = ""
-		state.currentAssistantContent = ""
-		state.currentAssistantTools = nil
-		state.mu.Unlock()
-
-	default:
-		return
+// historyLimit applies the default and maximum history page size
+func historyLimit(limit int) int {
+	if limit <= 0 {
+		return defaultHistoryPage
 	}
-
-	h.SendNotification(ctx, state, method, params)
+	return min(limit, maxHistoryPage)
 }
 
 // Helper functions
@@ -0,0 +1,227 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"github.com/Noon-R/Devport/server/auth"
+)
+
+// handleAuthRefresh exchanges a refresh token for a new session. The old
+// refresh token is invalid afterwards.
+func (h *Handler) handleAuthRefresh(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
+
+	client := auth.ClientFrom(ctx)
+	if resp := lockedOut(req, client); resp != nil {
+		return resp
+	}
+	token, session, err := h.tokens.Refresh(params.RefreshToken)
+	if err != nil {
+		client.Fail("ws auth.refresh", err)
+		return errorResponse(req.ID, ErrCodeAuthFailed, "Invalid refresh token")
+	}
+	client.Succeed()
+
+	return successResponse(req.ID, map[string]interface{}{
+		"access_token":  session.AccessToken,
+		"refresh_token": session.RefreshToken,
+		"expires_in":    session.ExpiresIn,
+		"scopes":        token.Scopes,
+	})
+}
+
+// handleAuthTicket issues a single-use ticket for the connection's token
+func (h *Handler) handleAuthTicket(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	ticket, err := h.tokens.Ticket(state.token)
+	if err != nil {
+		return errorResponse(req.ID, ErrCodeInternal, err.Error())
+	}
+	return successResponse(req.ID, map[string]interface{}{
+		"ticket":     ticket,
+		"expires_in": int(auth.TicketTTL / time.Second),
+	})
+}
+
+// lockedOut returns an error response if the client is locked out after
+// too many failed attempts (see auth.Guard), or nil
+func lockedOut(req *JSONRPCRequest, client *auth.Client) *JSONRPCResponse {
+	wait := client.LockedFor()
+	if wait <= 0 {
+		return nil
+	}
+	resp := errorResponse(req.ID, ErrCodeRateLimited, "Too many failed attempts")
+	resp.Error.Data = map[string]interface{}{
+		"retry_after": int(math.Ceil(wait.Seconds())),
+	}
+	return resp
+}
+
+// handleTokenList lists the named API tokens
+func (h *Handler) handleTokenList(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	return successResponse(req.ID, map[string]interface{}{
+		"tokens": h.tokens.List(),
+	})
+}
+
+// handleTokenCreate creates a named token. The secret is only returned here.
+func (h *Handler) handleTokenCreate(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int64    `json:"expires_in"` // seconds, 0 for no expiry
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.ExpiresIn < 0 {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
+
+	token, secret, err := h.tokens.Create(params.Name, params.Scopes, time.Duration(params.ExpiresIn)*time.Second)
+	if err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, err.Error())
+	}
+	log.Printf("Token %q created with scopes %v", token.Name, token.Scopes)
+
+	return successResponse(req.ID, map[string]interface{}{
+		"token":  token,
+		"secret": secret,
+	})
+}
+
+// handleTokenRevoke revokes a named token and closes the connections
+// authenticated with it
+func (h *Handler) handleTokenRevoke(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		TokenID string `json:"token_id"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
+
+	token, err := h.tokens.Revoke(params.TokenID)
+	if errors.Is(err, auth.ErrNotFound) {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Token not found")
+	}
+	if err != nil {
+		return errorResponse(req.ID, ErrCodeInternal, err.Error())
+	}
+	log.Printf("Token %q revoked", token.Name)
+	h.disconnectToken(token.ID, state)
+
+	return successResponse(req.ID, map[string]interface{}{
+		"token": token,
+	})
+}
+
+// handleDevicePair exchanges a pairing code for a device token. It needs no
+// authentication; the code is the credential.
+func (h *Handler) handleDevicePair(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		Code       string `json:"code"`
+		DeviceName string `json:"device_name"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
+
+	client := auth.ClientFrom(ctx)
+	if resp := lockedOut(req, client); resp != nil {
+		return resp
+	}
+	token, secret, err := h.tokens.Pair(params.Code, params.DeviceName)
+	if errors.Is(err, auth.ErrInvalidCode) {
+		client.Fail("ws device.pair", err)
+		return errorResponse(req.ID, ErrCodeAuthFailed, "Invalid or expired pairing code")
+	}
+	if errors.Is(err, auth.ErrPairingPaused) {
+		return errorResponse(req.ID, ErrCodeRateLimited, err.Error())
+	}
+	if err != nil {
+		return errorResponse(req.ID, ErrCodeInternal, err.Error())
+	}
+	client.Succeed()
+	log.Printf("Device %q paired", token.Name)
+
+	return successResponse(req.ID, map[string]interface{}{
+		"token":  token,
+		"secret": secret,
+	})
+}
+
+// handleDevicePairingCode issues a new pairing code
+func (h *Handler) handleDevicePairingCode(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	code, expires, err := h.tokens.NewPairingCode(h.cfg.PairingCodeTTL)
+	if err != nil {
+		return errorResponse(req.ID, ErrCodeInternal, err.Error())
+	}
+	return successResponse(req.ID, map[string]interface{}{
+		"code":       code,
+		"expires_at": expires,
+	})
+}
+
+// handleDeviceList lists the paired devices
+func (h *Handler) handleDeviceList(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	devices := h.tokens.Devices()
+	if devices == nil {
+		devices = []auth.Token{}
+	}
+	return successResponse(req.ID, map[string]interface{}{
+		"devices": devices,
+	})
+}
+
+// handleDeviceRevoke unpairs a device and closes its connections
+func (h *Handler) handleDeviceRevoke(ctx context.Context, state *ConnState, req *JSONRPCRequest) *JSONRPCResponse {
+	var params struct {
+		DeviceID string `json:"device_id"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Invalid params")
+	}
+
+	device := h.findDevice(params.DeviceID)
+	if device == nil {
+		return errorResponse(req.ID, ErrCodeInvalidParams, "Device not found")
+	}
+	token, err := h.tokens.Revoke(device.ID)
+	if err != nil {
+		return errorResponse(req.ID, ErrCodeInternal, err.Error())
+	}
+	log.Printf("Device %q revoked", token.Name)
+	h.disconnectToken(token.ID, state)
+
+	return successResponse(req.ID, map[string]interface{}{
+		"device": token,
+	})
+}
+
+// findDevice returns the paired device with the given ID, or nil
+func (h *Handler) findDevice(id string) *auth.Token {
+	for _, device := range h.tokens.Devices() {
+		if device.ID == id {
+			return &device
+		}
+	}
+	return nil
+}
+
+// disconnectToken closes the connections authenticated with a token, except
+// the calling one
+func (h *Handler) disconnectToken(id string, except *ConnState) {
+	h.conns.Range(func(key, value interface{}) bool {
+		conn := value.(*ConnState)
+		if conn != except && conn.token != nil && conn.token.ID == id {
+			conn.cancel()
+		}
+		return true
+	})
+}
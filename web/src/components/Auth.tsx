@@ -1,24 +1,57 @@
-import { type FormEvent, useState } from "react";
+import { type FormEvent, useEffect, useState } from "react";
 import { useFileStore } from "../lib/fileStore";
+import {
+	clearPairingCode,
+	currentServerUrl,
+	loadSavedToken,
+	pairDevice,
+	pairingCodeFromLocation,
+	saveToken,
+} from "../lib/pairing";
 import { useWsStore } from "../lib/wsStore";
 
 export function Auth() {
 	const [url, setUrl] = useState("ws://localhost:8080/ws");
-	const [token, setToken] = useState("");
+	const [token, setToken] = useState(loadSavedToken);
 	const [isConnecting, setIsConnecting] = useState(false);
 	const [error, setError] = useState<string | null>(null);
 	const connect = useWsStore((s) => s.connect);
 	const setFileConnection = useFileStore((s) => s.setConnection);
 
+	const connectWith = async (url: string, token: string) => {
+		await connect(url, token);
+		// Set connection info for file store
+		setFileConnection(url, token);
+		saveToken(token);
+	};
+
+	// Opened from the startup QR: pair this device and connect
+	useEffect(() => {
+		const code = pairingCodeFromLocation();
+		if (!code) {
+			return;
+		}
+		clearPairingCode();
+
+		const serverUrl = currentServerUrl();
+		setUrl(serverUrl);
+		setIsConnecting(true);
+		pairDevice(serverUrl, code)
+			.then((secret) => {
+				setToken(secret);
+				return connectWith(serverUrl, secret);
+			})
+			.catch((err) => setError(`Pairing failed: ${(err as Error).message}`))
+			.finally(() => setIsConnecting(false));
+	}, []);
+
 	const handleSubmit = async (e: FormEvent) => {
 		e.preventDefault();
 		setIsConnecting(true);
 		setError(null);
 
 		try {
-			await connect(url, token);
-			// Set connection info for file store
-			setFileConnection(url, token);
+			await connectWith(url, token);
 		} catch (err) {
 			setError((err as Error).message);
 		} finally {
@@ -8,10 +8,17 @@ export function ChatPanel() {
 	const currentSessionId = useWsStore((s) => s.currentSessionId);
 	const sendMessage = useWsStore((s) => s.sendMessage);
 	const interrupt = useWsStore((s) => s.interrupt);
+	const hasOlderHistory = useWsStore((s) => s.hasOlderHistory);
+	const loadOlderHistory = useWsStore((s) => s.loadOlderHistory);
 
 	return (
 		<div className="flex flex-col h-full bg-gray-900">
-			<MessageList messages={messages} isGenerating={isGenerating} />
+			<MessageList
+				messages={messages}
+				isGenerating={isGenerating}
+				hasOlderHistory={hasOlderHistory}
+				onLoadOlder={loadOlderHistory}
+			/>
 			<InputArea
 				onSend={sendMessage}
 				onInterrupt={interrupt}
@@ -6,15 +6,24 @@ import { ToolCallDisplay } from "./ToolCallDisplay";
 interface MessageListProps {
 	messages: Message[];
 	isGenerating: boolean;
+	hasOlderHistory?: boolean;
+	onLoadOlder?: () => void;
 }
 
-export function MessageList({ messages, isGenerating }: MessageListProps) {
+export function MessageList({
+	messages,
+	isGenerating,
+	hasOlderHistory,
+	onLoadOlder,
+}: MessageListProps) {
 	const bottomRef = useRef<HTMLDivElement>(null);
+	const lastMessage = messages[messages.length - 1];
 
+	// Scroll when the latest message changes, not when older ones are prepended
 	// biome-ignore lint/correctness/useExhaustiveDependencies: scroll on message change
 	useEffect(() => {
 		bottomRef.current?.scrollIntoView({ behavior: "smooth" });
-	}, [messages]);
+	}, [lastMessage]);
 
 	if (messages.length === 0) {
 		return (
@@ -29,6 +38,17 @@ export function MessageList({ messages, isGenerating }: MessageListProps) {
 
 	return (
 		<div className="flex-1 overflow-y-auto p-4 space-y-4">
+			{hasOlderHistory && onLoadOlder && (
+				<div className="flex justify-center">
+					<button
+						type="button"
+						onClick={onLoadOlder}
+						className="text-sm text-blue-400 hover:text-blue-300"
+					>
+						Load earlier messages
+					</button>
+				</div>
+			)}
 			{messages.map((message) => (
 				<MessageBubble key={message.id} message={message} />
 			))}
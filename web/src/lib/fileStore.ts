@@ -1,4 +1,5 @@
 import { create } from "zustand";
+import { useWsStore } from "./wsStore";
 
 // File info from server
 export interface FileInfo {
@@ -79,8 +80,10 @@ export const useFileStore = create<FileState>((set, get) => {
 	): Promise<Response> => {
 		const { baseUrl, token } = get();
 		const url = `${baseUrl}${endpoint}`;
+		// Prefer the short-lived access token of the WebSocket session
+		const bearer = useWsStore.getState().accessToken ?? token;
 		const headers = {
-			Authorization: `Bearer ${token}`,
+			Authorization: `Bearer ${bearer}`,
 			...options?.headers,
 		};
 		return fetch(url, { ...options, headers });
@@ -0,0 +1,74 @@
+// Device pairing with the one-time code from the server's startup QR.
+// The QR opens <server>/#pair=<code>; the code is exchanged for a
+// device token over the WebSocket, which also works through the relay.
+
+const TOKEN_KEY = "devport.token";
+
+/** Returns the pairing code in the page URL, if any */
+export function pairingCodeFromLocation(): string | null {
+	const match = window.location.hash.match(/[#&]pair=([^&]+)/);
+	return match ? decodeURIComponent(match[1]) : null;
+}
+
+/** Removes the pairing code from the address bar and history */
+export function clearPairingCode() {
+	window.history.replaceState(
+		null,
+		"",
+		window.location.pathname + window.location.search,
+	);
+}
+
+/** WebSocket URL of the server this page was loaded from */
+export function currentServerUrl(): string {
+	const protocol = window.location.protocol === "https:" ? "wss" : "ws";
+	return `${protocol}://${window.location.host}/ws`;
+}
+
+export function loadSavedToken(): string {
+	return localStorage.getItem(TOKEN_KEY) ?? "";
+}
+
+export function saveToken(token: string) {
+	localStorage.setItem(TOKEN_KEY, token);
+}
+
+/** Exchanges a pairing code for a device token secret */
+export function pairDevice(url: string, code: string): Promise<string> {
+	return new Promise((resolve, reject) => {
+		const ws = new WebSocket(url);
+		ws.onopen = () => {
+			ws.send(
+				JSON.stringify({
+					jsonrpc: "2.0",
+					method: "device.pair",
+					params: { code, device_name: deviceName() },
+					id: 1,
+				}),
+			);
+		};
+		ws.onmessage = (event) => {
+			const data = JSON.parse(event.data);
+			if (data.id !== 1) {
+				return;
+			}
+			ws.close();
+			if (data.error) {
+				reject(new Error(data.error.message));
+			} else {
+				resolve(data.result.secret);
+			}
+		};
+		ws.onerror = () => reject(new Error("Connection failed"));
+	});
+}
+
+function deviceName(): string {
+	const ua = navigator.userAgent;
+	for (const name of ["iPhone", "iPad", "Android", "Mac", "Windows", "Linux"]) {
+		if (ua.includes(name)) {
+			return name;
+		}
+	}
+	return "browser";
+}
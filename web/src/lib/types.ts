@@ -18,6 +18,7 @@ export interface ToolCall {
 // History message from server
 export interface HistoryMessage {
 	id: string;
+	seq: number;
 	role: "user" | "assistant" | "system";
 	content: string;
 	tool_calls?: HistoryToolCall[];
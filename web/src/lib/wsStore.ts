@@ -9,6 +9,9 @@ import type {
 	UserQuestion,
 } from "./types";
 
+// JSON-RPC protocol version spoken by this client (see docs/api-reference.md)
+const PROTOCOL_VERSION = 2;
+
 interface WsState {
 	// Connection state
 	connectionState: ConnectionState;
@@ -17,6 +20,8 @@ interface WsState {
 	// Connection info (for reconnect)
 	wsUrl: string | null;
 	authToken: string | null;
+	// Short-lived token for REST requests, renewed while connected
+	accessToken: string | null;
 
 	// Session
 	currentSessionId: string | null;
@@ -26,6 +31,9 @@ interface WsState {
 	messages: Message[];
 	isGenerating: boolean;
 	lastMessageId: string | null;
+	// chat.attach returns the latest page; older pages are loaded on demand
+	oldestSeq: number | null;
+	hasOlderHistory: boolean;
 
 	// Pending interactions
 	pendingPermission: PermissionRequest | null;
@@ -37,6 +45,7 @@ interface WsState {
 	sendMessage: (content: string) => Promise<void>;
 	interrupt: () => Promise<void>;
 	attachSession: (sessionId: string) => Promise<void>;
+	loadOlderHistory: () => Promise<void>;
 	createSession: (title?: string) => Promise<Session>;
 	loadSessions: () => Promise<void>;
 	respondToPermission: (allowed: boolean) => Promise<void>;
@@ -50,6 +59,33 @@ let reconnectAttempts = 0;
 let reconnectTimeout: ReturnType<typeof setTimeout> | null = null;
 const MAX_RECONNECT_ATTEMPTS = 10;
 const BASE_RECONNECT_DELAY = 1000;
+let heartbeatTimer: ReturnType<typeof setInterval> | null = null;
+const HEARTBEAT_INTERVAL = 25000;
+const HEARTBEAT_TIMEOUT = 10000;
+let refreshTimer: ReturnType<typeof setTimeout> | null = null;
+// Renew the access token this long before it expires
+const REFRESH_MARGIN = 60000;
+
+interface AuthSession {
+	access_token: string;
+	refresh_token: string;
+	expires_in: number;
+}
+
+// Convert a history message to the local Message format
+const toMessage = (hm: HistoryMessage): Message => ({
+	id: hm.id,
+	role: hm.role,
+	content: hm.content,
+	toolCalls: hm.tool_calls?.map((tc) => ({
+		id: tc.id,
+		name: tc.name,
+		input: tc.input,
+		output: tc.output,
+		status: tc.status as "pending" | "completed" | "error",
+	})),
+	timestamp: new Date(hm.timestamp),
+});
 
 export const useWsStore = create<WsState>((set, get) => {
 	let currentAssistantMessage: Message | null = null;
@@ -74,12 +110,12 @@ export const useWsStore = create<WsState>((set, get) => {
 		endpoint: string,
 		options?: RequestInit,
 	): Promise<Response> => {
-		const { authToken } = get();
+		const { accessToken } = get();
 		const url = `${getHttpUrl()}${endpoint}`;
 		return fetch(url, {
 			...options,
 			headers: {
-				Authorization: `Bearer ${authToken}`,
+				Authorization: `Bearer ${accessToken}`,
 				"Content-Type": "application/json",
 				...options?.headers,
 			},
@@ -249,6 +285,53 @@ export const useWsStore = create<WsState>((set, get) => {
 		});
 	};
 
+	// Ping the server periodically; a connection that stops answering is
+	// closed so that the reconnect logic takes over
+	const startHeartbeat = () => {
+		stopHeartbeat();
+		heartbeatTimer = setInterval(() => {
+			const socket = ws;
+			const timeout = new Promise((_, reject) =>
+				setTimeout(() => reject(new Error("ping timeout")), HEARTBEAT_TIMEOUT),
+			);
+			Promise.race([sendRpcRequest("ping", {}), timeout]).catch(() => {
+				if (socket === ws) socket?.close();
+			});
+		}, HEARTBEAT_INTERVAL);
+	};
+
+	const stopHeartbeat = () => {
+		if (heartbeatTimer) {
+			clearInterval(heartbeatTimer);
+			heartbeatTimer = null;
+		}
+	};
+
+	// Keep the access token fresh by exchanging the refresh token shortly
+	// before it expires
+	const startSession = (session: AuthSession) => {
+		stopSession();
+		set({ accessToken: session.access_token });
+		const delay = Math.max(session.expires_in * 1000 - REFRESH_MARGIN, 0);
+		refreshTimer = setTimeout(() => {
+			sendRpcRequest("auth.refresh", {
+				refresh_token: session.refresh_token,
+			})
+				.then((next) => startSession(next as AuthSession))
+				.catch(() => {
+					// Reconnecting authenticates again and starts a new session
+					ws?.close();
+				});
+		}, delay);
+	};
+
+	const stopSession = () => {
+		if (refreshTimer) {
+			clearTimeout(refreshTimer);
+			refreshTimer = null;
+		}
+	};
+
 	// Attempt reconnection
 	const attemptReconnect = () => {
 		const { wsUrl, authToken, currentSessionId } = get();
@@ -286,11 +369,14 @@ export const useWsStore = create<WsState>((set, get) => {
 		error: null,
 		wsUrl: null,
 		authToken: null,
+		accessToken: null,
 		currentSessionId: null,
 		sessions: [],
 		messages: [],
 		isGenerating: false,
 		lastMessageId: null,
+		oldestSeq: null,
+		hasOlderHistory: false,
 		pendingPermission: null,
 		pendingQuestion: null,
 
@@ -315,9 +401,14 @@ export const useWsStore = create<WsState>((set, get) => {
 					set({ connectionState: "connected" });
 
 					try {
-						await sendRpcRequest("auth", { token });
+						const session = (await sendRpcRequest("auth", {
+							token,
+							protocol_version: PROTOCOL_VERSION,
+						})) as AuthSession;
+						startSession(session);
 						set({ connectionState: "authenticated" });
 						reconnectAttempts = 0;
+						startHeartbeat();
 						resolve();
 					} catch (e) {
 						set({
@@ -351,6 +442,8 @@ export const useWsStore = create<WsState>((set, get) => {
 				};
 
 				ws.onclose = () => {
+					stopHeartbeat();
+					stopSession();
 					const wasAuthenticated = get().connectionState === "authenticated";
 					set({ connectionState: "disconnected" });
 					pendingRequests.clear();
@@ -378,6 +471,8 @@ export const useWsStore = create<WsState>((set, get) => {
 				reconnectTimeout = null;
 			}
 			reconnectAttempts = MAX_RECONNECT_ATTEMPTS; // Prevent auto-reconnect
+			stopHeartbeat();
+			stopSession();
 
 			ws?.close();
 			ws = null;
@@ -387,6 +482,7 @@ export const useWsStore = create<WsState>((set, get) => {
 				messages: [],
 				wsUrl: null,
 				authToken: null,
+				accessToken: null,
 			});
 		},
 
@@ -457,28 +553,26 @@ export const useWsStore = create<WsState>((set, get) => {
 
 		attachSession: async (sessionId: string) => {
 			try {
+				// Stop receiving the events of the session we are leaving
+				const previous = get().currentSessionId;
+				if (previous && previous !== sessionId) {
+					await sendRpcRequest("chat.detach", { session_id: previous }).catch(
+						() => {},
+					);
+				}
+
 				const result = (await sendRpcRequest("chat.attach", {
 					session_id: sessionId,
 				})) as {
 					session_id: string;
 					status: string;
 					history: HistoryMessage[];
+					has_more: boolean;
 				};
 
 				// Convert history messages to local Message format
-				const messages: Message[] = (result.history || []).map((hm) => ({
-					id: hm.id,
-					role: hm.role,
-					content: hm.content,
-					toolCalls: hm.tool_calls?.map((tc) => ({
-						id: tc.id,
-						name: tc.name,
-						input: tc.input,
-						output: tc.output,
-						status: tc.status as "pending" | "completed" | "error",
-					})),
-					timestamp: new Date(hm.timestamp),
-				}));
+				const history = result.history || [];
+				const messages = history.map(toMessage);
 
 				const lastId =
 					messages.length > 0 ? messages[messages.length - 1].id : null;
@@ -487,6 +581,8 @@ export const useWsStore = create<WsState>((set, get) => {
 					currentSessionId: sessionId,
 					messages,
 					lastMessageId: lastId,
+					oldestSeq: history.length > 0 ? history[0].seq : null,
+					hasOlderHistory: result.has_more,
 					pendingPermission: null,
 					pendingQuestion: null,
 				});
@@ -495,10 +591,37 @@ export const useWsStore = create<WsState>((set, get) => {
 			}
 		},
 
+		// Prepend the page of history before the oldest loaded message
+		loadOlderHistory: async () => {
+			const { currentSessionId, oldestSeq, hasOlderHistory } = get();
+			if (!currentSessionId || oldestSeq === null || !hasOlderHistory) return;
+
+			try {
+				const result = (await sendRpcRequest("session.get_history", {
+					session_id: currentSessionId,
+					before: oldestSeq,
+				})) as { messages: HistoryMessage[]; has_more: boolean };
+
+				// Ignore the page if the user switched sessions meanwhile
+				if (get().currentSessionId !== currentSessionId) return;
+
+				const older = result.messages || [];
+				set((state) => ({
+					messages: [...older.map(toMessage), ...state.messages],
+					oldestSeq: older.length > 0 ? older[0].seq : state.oldestSeq,
+					hasOlderHistory: result.has_more,
+				}));
+			} catch (e) {
+				set({ error: (e as Error).message });
+			}
+		},
+
 		createSession: async (title?: string) => {
-			const result = (await sendRpcRequest("session.create", {
-				title: title || "New Chat",
-			})) as { session: Session };
+			// Without a title the server generates one after the first exchange
+			const result = (await sendRpcRequest(
+				"session.create",
+				title ? { title } : {},
+			)) as { session: Session };
 			const session = result.session;
 			set((state) => ({
 				sessions: [...state.sessions, session],
@@ -588,21 +711,7 @@ export const useWsStore = create<WsState>((set, get) => {
 				if (!response.ok) return;
 
 				const data = await response.json();
-				const newMessages: Message[] = (data.messages || []).map(
-					(hm: HistoryMessage) => ({
-						id: hm.id,
-						role: hm.role,
-						content: hm.content,
-						toolCalls: hm.tool_calls?.map((tc) => ({
-							id: tc.id,
-							name: tc.name,
-							input: tc.input,
-							output: tc.output,
-							status: tc.status as "pending" | "completed" | "error",
-						})),
-						timestamp: new Date(hm.timestamp),
-					}),
-				);
+				const newMessages: Message[] = (data.messages || []).map(toMessage);
 
 				if (newMessages.length > 0) {
 					set((state) => ({